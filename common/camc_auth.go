@@ -0,0 +1,151 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// How a pattern manager resource authenticates, set with auth_mode. Each
+// sends exactly one credential.
+const (
+	// Authorization: Bearer with access_token, or a token the provider obtains
+	AuthModeBearer = "bearer"
+	// Authorization: Basic with username and password
+	AuthModeBasic = "basic"
+	// Only the client certificate in cert_file and key_file, or cert_pem and key_pem
+	AuthModeMTLS = "mtls"
+	// api_key in the header named by api_key_header
+	AuthModeAPIKey = "api_key"
+)
+
+var AuthModes = []string{AuthModeBearer, AuthModeBasic, AuthModeMTLS, AuthModeAPIKey}
+
+// CustomizeDiff of the pattern manager resources. Checks that camc_endpoint
+// can be resolved, that the credentials for auth_mode, and only those, are
+// set, that the bastion host has credentials and that data can be sent as
+// content_type. Outputs are extracted again when output_paths change.
+func PatternManagerCustomizeDiff(ctx context.Context, diff *schema.ResourceDiff, m interface{}) error {
+	checks := []func(configReader) error{
+		func(c configReader) error { return checkEndpoint(c, m) },
+		func(c configReader) error { return checkAuth(c, m) },
+		func(c configReader) error { return checkBastion(c, m) },
+		checkContentType,
+	}
+	for _, check := range checks {
+		if err := check(diff); err != nil {
+			return planError(err)
+		}
+	}
+	if diff.Id() != "" && diff.HasChange("output_paths") {
+		return diff.SetNewComputed("outputs")
+	}
+	return nil
+}
+
+// rawConfigReader reads the configuration as it's written, such as
+// ResourceData and ResourceDiff
+type rawConfigReader interface {
+	GetRawConfigAt(path cty.Path) (cty.Value, diag.Diagnostics)
+}
+
+// Returns the auth_mode of a pattern manager resource. Before auth_mode,
+// username and password were sent as basic auth whatever else was set. A
+// resource that sets both but not auth_mode still sends them for now, and
+// legacy reports it.
+func resolveAuthMode(c configReader) (authMode string, legacy bool) {
+	authMode = c.Get("auth_mode").(string)
+	if authMode != AuthModeBearer || c.Get("username").(string) == "" || c.Get("password").(string) == "" {
+		return authMode, false
+	}
+	// a destroy has no configuration, the resource was planned with it
+	if r, ok := c.(rawConfigReader); ok {
+		if v, diags := r.GetRawConfigAt(cty.GetAttrPath("auth_mode")); !diags.HasError() && !v.IsNull() {
+			return authMode, false
+		}
+	}
+	return AuthModeBasic, true
+}
+
+// ValidateRawResourceConfigFunc of the pattern manager resources. Warns that
+// username and password are only sent as basic auth because auth_mode isn't
+// set, see resolveAuthMode.
+func ValidateLegacyAuth(ctx context.Context, req schema.ValidateResourceConfigFuncRequest, resp *schema.ValidateResourceConfigFuncResponse) {
+	config := req.RawConfig
+	if config.IsNull() || !config.IsKnown() || !config.GetAttr("auth_mode").IsNull() {
+		return
+	}
+	set := func(key string) bool {
+		v := config.GetAttr(key)
+		return v.IsKnown() && !v.IsNull() && v.AsString() != ""
+	}
+	if !set("username") || !set("password") {
+		return
+	}
+	resp.Diagnostics = append(resp.Diagnostics, diag.Diagnostic{
+		Severity: diag.Warning,
+		Summary:  "username and password are sent as basic auth because auth_mode isn't set",
+		Detail: `Set auth_mode = "basic" to keep sending them, or remove them to send access_token. ` +
+			"In the next release this is an error.",
+		AttributePath: cty.GetAttrPath("auth_mode"),
+	})
+}
+
+// Checks the credentials of a pattern manager resource against its auth_mode
+func checkAuth(c configReader, m interface{}) error {
+	keys := []string{"auth_mode", "access_token", "username", "password", "api_key", "api_key_header", "cert_file", "key_file", "cert_pem", "key_pem"}
+	if !isKnown(c, keys...) {
+		return nil
+	}
+	authMode, legacy := resolveAuthMode(c)
+	set := func(key string) bool {
+		return c.Get(key).(string) != ""
+	}
+	// the credentials of the other modes must not be set
+	unused := func(keys ...string) error {
+		for _, key := range keys {
+			if set(key) {
+				return &Error{Summary: key + " is not used when auth_mode is " + authMode, Attribute: key}
+			}
+		}
+		return nil
+	}
+	switch authMode {
+	case AuthModeBearer:
+		if !set("access_token") && providerMeta(m).AccessToken == "" && providerTokens(m) == nil {
+			return &Error{Summary: "No access_token supplied, cannot connect to pattern manager",
+				Detail: "Set access_token, here or for the provider, or the credentials the provider obtains access tokens with.", Attribute: "access_token"}
+		}
+		return unused("username", "password", "api_key")
+	case AuthModeBasic:
+		if !set("username") || !set("password") {
+			return &Error{Summary: "username and password are required when auth_mode is basic", Attribute: "username"}
+		}
+		// access_token was ignored before auth_mode
+		if legacy {
+			return unused("api_key")
+		}
+		return unused("access_token", "api_key")
+	case AuthModeMTLS:
+		if !set("cert_file") && !set("cert_pem") || !set("key_file") && !set("key_pem") {
+			return &Error{Summary: "A client certificate is required when auth_mode is mtls",
+				Detail: "Set cert_file or cert_pem along with key_file or key_pem.", Attribute: "cert_file"}
+		}
+		return unused("access_token", "username", "password", "api_key")
+	case AuthModeAPIKey:
+		if !set("api_key") {
+			return &Error{Summary: "api_key is required when auth_mode is api_key", Attribute: "api_key"}
+		}
+		if !set("api_key_header") {
+			return &Error{Summary: "api_key_header is required when auth_mode is api_key", Attribute: "api_key_header"}
+		}
+		return unused("access_token", "username", "password")
+	}
+	return &Error{Summary: "Unknown auth_mode " + authMode, Attribute: "auth_mode"}
+}
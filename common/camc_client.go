@@ -0,0 +1,155 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// HTTPTimeouts limit how long the steps of a pattern manager request take
+type HTTPTimeouts struct {
+	// To connect to the server
+	Connect time.Duration
+	// For the TLS handshake
+	TLSHandshake time.Duration
+	// From sending the request to the headers of the response
+	ResponseHeader time.Duration
+	// For the whole request, the response body included
+	Request time.Duration
+}
+
+// Timeouts unless the provider configuration sets them. The response and the
+// request as a whole are only limited by the timeouts of the resource.
+var DefaultHTTPTimeouts = HTTPTimeouts{
+	Connect:      15 * time.Second,
+	TLSHandshake: 10 * time.Second,
+}
+
+// ClientPool holds the HTTP clients of a provider configuration, one for each
+// TLS configuration of its resources and one for its token requests. Their
+// connections are kept alive and reused by the requests that follow.
+type ClientPool struct {
+	Timeouts HTTPTimeouts
+	// The proxy of the requests, the environment's if it's nil
+	Proxy *ProxyConfig
+
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+func NewClientPool(timeouts HTTPTimeouts, proxy *ProxyConfig) *ClientPool {
+	return &ClientPool{Timeouts: timeouts, Proxy: proxy, clients: make(map[string]*http.Client)}
+}
+
+// Used by resources that don't have the meta of a provider
+var defaultClients = NewClientPool(DefaultHTTPTimeouts, nil)
+
+// Returns the client for tlsConfig, creating it the first time key is asked
+// for. Resources with the same TLS settings get the same key. A client with
+// dial opens its connections with it, and not through the proxy.
+func (p *ClientPool) Client(key string, tlsConfig *tls.Config, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *http.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if client, ok := p.clients[key]; ok {
+		return client
+	}
+	transport := &http.Transport{
+		Proxy:           p.Proxy.httpProxy(),
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   p.Timeouts.Connect,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		// a transport with its own TLS configuration only speaks HTTP/2 if asked
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   p.Timeouts.TLSHandshake,
+		ResponseHeaderTimeout: p.Timeouts.ResponseHeader,
+		ExpectContinueTimeout: time.Second,
+	}
+	if dial != nil {
+		transport.Proxy = nil
+		transport.DialContext = dial
+	}
+	client := &http.Client{Transport: transport, Timeout: p.Timeouts.Request}
+	p.clients[key] = client
+	return client
+}
+
+// Returns a key that's the same for resources with the same values of keys.
+// The values are hashed so the secrets among them aren't kept.
+func ClientKey(d *schema.ResourceData, keys ...string) string {
+	h := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s=%v\x00", key, d.Get(key))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Returns the client pool of the provider
+func ProviderClients(m interface{}) *ClientPool {
+	if meta, ok := m.(*ProviderMeta); ok && meta.Clients != nil {
+		return meta.Clients
+	}
+	return defaultClients
+}
+
+// The attributes that tlsClientConfig reads, a client is shared by resources
+// with the same values
+var tlsAttributes = []string{"skip_ssl_verify", "tls_server_name", "min_tls_version", "ca_file", "ca_pem", "cert_file", "key_file", "cert_pem", "key_pem"}
+
+// Returns the client for the TLS and bastion settings of a pattern manager
+// resource
+func patternManagerClient(d *schema.ResourceData, m interface{}) (*http.Client, *tls.Config, error) {
+	tlsConfig, err := tlsClientConfig(d, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	tunnel, err := patternManagerTunnel(d, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	var dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	if tunnel != nil {
+		dial = tunnel.DialContext
+	}
+	key := ClientKey(d, append(tlsAttributes, bastionAttributes...)...)
+	return ProviderClients(m).Client(key, tlsConfig, dial), tlsConfig, nil
+}
+
+// Returns the client for the TLS settings of a resource that's not reached
+// through a bastion host, such as camc
+func TLSClient(d *schema.ResourceData, m interface{}) (*http.Client, error) {
+	tlsConfig, err := tlsClientConfig(d, m)
+	if err != nil {
+		return nil, err
+	}
+	return ProviderClients(m).Client(ClientKey(d, tlsAttributes...), tlsConfig, nil), nil
+}
+
+// Validates that a string attribute holds a duration such as 30s or 5m
+func ValidateDuration(i interface{}, path cty.Path) diag.Diagnostics {
+	v, ok := i.(string)
+	if !ok || v == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(v); err != nil || d < 0 {
+		return attributeDiagnostics(path, fmt.Sprintf("Invalid duration %q", v), "A duration such as 30s, 5m or 1h30m is expected.")
+	}
+	return nil
+}
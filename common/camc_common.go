@@ -5,72 +5,28 @@
 package common
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
-func TraceMessage(d *schema.ResourceData, msg string) string {
-	//Escape percentage from Sprintf formatting
-	msg = strings.Replace(msg, "%", "%%", -1)
-	var s string = fmt.Sprintf("\n**********\n%s\n**********", msg)
-	if d.Get("trace").(bool) == true {
-		log.SetFlags(0)
-		log.Print(s)
-	}
-	return s
-}
-
-func ErrorMessage(d *schema.ResourceData, msg string, err_msg string) string {
-	//Escape percentage from Sprintf formatting
-	msg = strings.Replace(msg, "%", "%%", -1)
-	err_msg = strings.Replace(err_msg, "%", "%%", -1)
-	var s string = fmt.Sprintf("\n**********\n%s\n%s\n**********", msg, err_msg)
-	if d.Get("trace").(bool) == true {
-		log.SetFlags(0)
-		log.Print(s)
-	}
-	return s
-}
-
-// Like ErrorMessage but without the stars. Use when a parent method is going to use ErrorMessage to wrap your error
-func SubErrorMessage(d *schema.ResourceData, msg string, err_msg string) string {
-	//Escape percentage from Sprintf formatting
-	msg = strings.Replace(msg, "%", "%%", -1)
-	err_msg = strings.Replace(err_msg, "%", "%%", -1)
-	var s string = fmt.Sprintf("\n%s\n%s\n", msg, err_msg)
-	if d.Get("trace").(bool) == true {
-		log.SetFlags(0)
-		log.Print(s)
-	}
-	return s
-}
-
-func TraceMessagef(d *schema.ResourceData, fmt string, msg string) {
-	if d.Get("trace").(bool) == true {
-		log.SetFlags(0)
-		log.Printf(fmt, msg)
-	}
-}
-
 func GenUUID() string {
 	b := make([]byte, 16)
 	_, err := rand.Read(b)
@@ -82,162 +38,253 @@ func GenUUID() string {
 	return uuid
 }
 
-func MakeRequest(d *schema.ResourceData, m interface{}, method string) (string, error) {
+// Returns the JSON document in attribute compacted to send as a request
+// body, or nil for "null". A write-only attribute named attribute_wo takes
+// the place of attribute when it's set. Nothing is sent once the document has
+// been replaced by its hash in state.
+func JSONRequestBody(d *schema.ResourceData, attribute string) ([]byte, error) {
+	data := d.Get(attribute).(string)
+	if v, ok := configString(d, attribute+"_wo"); ok {
+		attribute += "_wo"
+		data = v
+	}
+	if data == "" || data == "null" || IsDataHash(data) {
+		return nil, nil
+	}
+	b := new(bytes.Buffer)
+	if err := json.Compact(b, []byte(data)); err != nil {
+		// don't echo the data, it may have secrets
+		return nil, NewAttributeError(d, attribute, attribute+" is not valid json", "")
+	}
+	return b.Bytes(), nil
+}
+
+func MakeRequest(ctx context.Context, d *schema.ResourceData, m interface{}, method string) (*Response, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	ctx = LoggingContext(ctx, d)
+
 	//get all possible inputs
-	camc_endpoint := d.Get("camc_endpoint").(string)
-	data := d.Get("data").(string)
 	username := d.Get("username").(string)
 	password := d.Get("password").(string)
 	certFile := d.Get("cert_file").(string)
-	keyFile := d.Get("key_file").(string)
-	caFile := d.Get("ca_file").(string)
 	skip_ssl_verify := d.Get("skip_ssl_verify").(bool)
 	access_token := d.Get("access_token").(string)
+	authMode, _ := resolveAuthMode(d)
+	apiKey := d.Get("api_key").(string)
+	apiKeyHeader := d.Get("api_key_header").(string)
 
-	//initialize a tlsConfig structure
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: skip_ssl_verify,
+	// checked at plan time too, unless the values weren't known then
+	if err := checkAuth(d, m); err != nil {
+		return nil, append(diags, Diagnostics(err)...)
 	}
-
-	//if client cert information provided use it to setup the tlsConfig structure
-	if certFile != "" && keyFile != "" && caFile != "" {
-		TraceMessage(d, "start using client cert connectivity")
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// Load CA cert
-		caCert, err := ioutil.ReadFile(caFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-
-		// Setup HTTPS client
-		tlsConfig.Certificates = []tls.Certificate{cert}
-		tlsConfig.RootCAs = caCertPool
-		tlsConfig.BuildNameToCertificate()
+	camc_endpoint, err := patternManagerURL(d, m)
+	if err != nil {
+		return nil, append(diags, Diagnostics(NewAttributeError(d, "camc_endpoint", "Invalid camc_endpoint", err.Error()))...)
 	}
 
-	//initialize the http transport
-	//GO 1.9.x support only http. GO 1.10 supports https
-	//We may need to move to GO 1.1.0
-	tr := &http.Transport{
-		Proxy:           http.ProxyFromEnvironment,
-		TLSClientConfig: tlsConfig,
-		Dial: (&net.Dialer{
-			Timeout:   15 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).Dial,
+	if skip_ssl_verify {
+		diags = append(diags, Warning(d, "TLS certificate verification is disabled",
+			fmt.Sprintf("The certificate of %s is not verified because skip_ssl_verify is true.", camc_endpoint)))
 	}
 
-	//initialize the http client with the defined transport
-	client := &http.Client{
-		Transport: tr,
+	// connections are reused by the resources with the same TLS settings
+	client, tlsConfig, err := patternManagerClient(d, m)
+	if err != nil {
+		return nil, append(diags, Diagnostics(err)...)
+	}
+	if len(tlsConfig.Certificates) > 0 {
+		TraceMessage(ctx, d, SubsystemHTTP, "start using client cert connectivity", map[string]interface{}{"cert_file": certFile})
+	}
+	if bastionHost := d.Get("bastion_host").(string); bastionHost != "" {
+		TraceMessage(ctx, d, SubsystemHTTP, "Sending requests through bastion host", map[string]interface{}{"bastion_host": bastionHost})
 	}
 
 	//process the input data
-	var json_string map[string]interface{}
-
-	if data != "null" {
-		if nil != json.Unmarshal([]byte(data), &json_string) {
-			err := fmt.Errorf(ErrorMessage(d, "data is not valid json", data))
-			return "", err
-		}
-
+	body, err := JSONRequestBody(d, "data")
+	if err != nil {
+		return nil, append(diags, Diagnostics(err)...)
 	}
+	contentType := d.Get("content_type").(string)
+	if body, err = encodeBody(body, contentType); err != nil {
+		return nil, append(diags, Diagnostics(NewAttributeError(d, "data", "data can't be sent as "+contentType, err.Error()))...)
+	}
+	header := requestHeaders(d, m)
 
-	b := new(bytes.Buffer)
-	if len(json_string) != 0 {
-		json.NewEncoder(b).Encode(json_string)
+	// a static access_token wins, the resource's then the provider's,
+	// otherwise the provider obtains one
+	if access_token == "" {
+		access_token = providerMeta(m).AccessToken
+	}
+	tokens := providerTokens(m)
+	token := access_token
+	if authMode == AuthModeBearer && token == "" {
+		var err error
+		token, err = tokens.Token(ctx)
+		if err != nil {
+			return nil, append(diags, Diagnostics(NewError(d, "Unable to obtain an access token", err.Error()))...)
+		}
 	}
 
 	//setup the http request
-	req, _ := http.NewRequest(method, camc_endpoint, b)
-	req.Close = true
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	if access_token != "" {
-		req.Header.Add("Authorization", "Bearer "+access_token)
-	} else {
-		err := fmt.Errorf(ErrorMessage(d, "No access_token supplied, cannot connect to pattern manager", ""))
-		return "", err
+	newRequest := func(method string, url string, body []byte, token string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("User-Agent", UserAgent(m))
+		// polls have no body to describe
+		if method != http.MethodGet {
+			req.Header.Set("Content-Type", contentType)
+		}
+		// the credentials and headers are only for the pattern manager, not
+		// for an operation it says to poll on another host
+		if !sameOrigin(url, camc_endpoint) {
+			return req, nil
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		switch authMode {
+		case AuthModeBearer:
+			req.Header.Set("Authorization", "Bearer "+token)
+		case AuthModeBasic:
+			req.SetBasicAuth(username, password)
+		case AuthModeAPIKey:
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		return req, nil
 	}
 
-	if username != "" && password != "" {
-		req.SetBasicAuth(username, password)
+	//make the call, and return the response with its body read
+	send := func(method string, url string, body []byte) (*http.Response, string, error) {
+		req, err := newRequest(method, url, body, token)
+		if err != nil {
+			return nil, "", NewAttributeError(d, "camc_endpoint", "Invalid pattern manager request", err.Error())
+		}
+		credentials := sameOrigin(url, camc_endpoint)
+		if credentials {
+			TraceMessage(ctx, d, SubsystemHTTP, "Sending request to pattern manager", map[string]interface{}{"method": method, "url": url, "content_type": contentType, "headers": headerNames(header)})
+		} else {
+			TraceMessage(ctx, d, SubsystemHTTP, "Sending request without credentials to another host", map[string]interface{}{"method": method, "url": url})
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && credentials && authMode == AuthModeBearer && access_token == "" {
+			// the token expired or was revoked, authenticate again and retry once
+			resp.Body.Close()
+			tokens.Invalidate(token)
+			TraceMessage(ctx, d, SubsystemHTTP, "Access token rejected, obtaining a new one", map[string]interface{}{"method": method, "url": url})
+			if token, err = tokens.Token(ctx); err != nil {
+				return nil, "", NewError(d, "Unable to obtain an access token", err.Error())
+			}
+			if req, err = newRequest(method, url, body, token); err != nil {
+				return nil, "", NewAttributeError(d, "camc_endpoint", "Invalid pattern manager request", err.Error())
+			}
+			resp, err = client.Do(req)
+		}
+		if err != nil {
+			return nil, "", NewError(d, "Unable to connect to endpoint "+url, err.Error())
+		}
+
+		//access the response body
+		defer resp.Body.Close()
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, "", NewError(d, "Error reading the response from "+url, err.Error())
+		}
+		fields := map[string]interface{}{
+			"method":      method,
+			"url":         url,
+			"status_code": resp.StatusCode,
+			"duration_ms": time.Since(start).Milliseconds(),
+		}
+		if id := requestID(resp); id != "" {
+			fields["request_id"] = id
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			fields["body"] = string(respBody)
+			TraceMessage(ctx, d, SubsystemHTTP, "Good response from pattern manager", fields)
+		} else {
+			TraceMessage(ctx, d, SubsystemHTTP, "Bad response from pattern manager", fields)
+		}
+		return resp, string(respBody), nil
 	}
 
-	//make the call
-	resp, err := client.Do(req)
+	resp, rb, err := send(method, camc_endpoint, body)
 	if err != nil {
 		//return all errors
-		msgStr := []string{"Unable to connect to endpoint ", camc_endpoint}
-		err := fmt.Errorf(ErrorMessage(d, strings.Join(msgStr, ""), err.Error()))
-		return "", err
+		return nil, append(diags, Diagnostics(err)...)
 	}
 
-	//access the response body
-	defer resp.Body.Close()
-	respBody, _ := ioutil.ReadAll(resp.Body)
-
-	rb := string(respBody)
-
 	// don't save the data, may have secrets
-	//TraceMessage(d, "[Trace] setting data to 'null' so not stored in state file")
+	//TraceMessage(ctx, d, SubsystemHTTP, "setting data to 'null' so not stored in state file")
 	//d.Set("data", "null")
 
-	if resp.StatusCode == 200 || resp.StatusCode == 201 {
-		TraceMessage(d, fmt.Sprintf("Good response:\nStatusCode:%v\nMessage:\n%s", resp.StatusCode, rb))
-		return rb, nil
-	} else {
+	operation := OperationOf(method)
+	if IsGone(operation, resp.StatusCode) {
+		TraceMessage(ctx, d, SubsystemHTTP, "Resource already deleted from pattern manager", map[string]interface{}{"url": camc_endpoint, "status_code": resp.StatusCode})
+		return &Response{StatusCode: resp.StatusCode, Body: rb}, diags
+	}
+	if !ExpectedStatus(d, operation, resp.StatusCode) {
 		//return all errors
-		err := fmt.Errorf(ErrorMessage(d, "Error: Response from pattern manager:", fmt.Sprintf("StatusCode:%v\nMessage:\n%s", resp.StatusCode, rb)))
-		return "", err
+		return nil, append(diags, Diagnostics(ResponseError(d, resp, rb))...)
+	}
+	// an operation that runs on is polled until it's done
+	resp, rb, err = PollOperation(ctx, d, operation, resp, rb, func(url string) (*http.Response, string, error) {
+		return send(http.MethodGet, url, nil)
+	})
+	if err != nil {
+		return nil, append(diags, Diagnostics(err)...)
 	}
+	return &Response{StatusCode: resp.StatusCode, Body: rb}, diags
 }
 
 func CreateSSHConfig(d *schema.ResourceData, m interface{}) (*ssh.ClientConfig, error) {
 	remoteUser := d.Get("remote_user").(string)
 	remotePassword := d.Get("remote_password").(string)
 	remoteKeyEnc := d.Get("remote_key").(string)
+	if remotePassword == "" && remoteKeyEnc == "" {
+		remoteKeyEnc = providerMeta(m).SSHPrivateKey
+	}
 
 	if remoteUser == "" {
-		return nil, fmt.Errorf(ErrorMessage(d, "Error: remote_user is required when specifying remote_host", ""))
+		return nil, NewAttributeError(d, "remote_user", "remote_user is required when specifying remote_host", "")
 	}
-	config, err := createClientConfig(d, remoteUser, remotePassword, remoteKeyEnc)
+	config, err := createClientConfig(d, remoteUser, remotePassword, remoteKeyEnc, "remote_key")
 	if err != nil {
 		return nil, err
 	}
 	return config, nil
 }
 
-func CreateBastionConfig(d *schema.ResourceData) (*ssh.ClientConfig, error) {
+func CreateBastionConfig(d *schema.ResourceData, m interface{}) (*ssh.ClientConfig, error) {
 	bastionUser := d.Get("bastion_user").(string)
 	bastionPassword := d.Get("bastion_password").(string)
 	bastionKeyEnc := d.Get("bastion_private_key").(string)
+	if bastionPassword == "" && bastionKeyEnc == "" {
+		bastionKeyEnc = providerMeta(m).SSHPrivateKey
+	}
 	if bastionUser == "" {
-		return nil, fmt.Errorf(ErrorMessage(d, "Error: bastion_user is required when specifying bastion_host", ""))
+		return nil, NewAttributeError(d, "bastion_user", "bastion_user is required when specifying bastion_host", "")
 	}
-	config, err := createClientConfig(d, bastionUser, bastionPassword, bastionKeyEnc)
+	config, err := createClientConfig(d, bastionUser, bastionPassword, bastionKeyEnc, "bastion_private_key")
 	if err != nil {
 		return nil, err
 	}
 	return config, nil
 }
 
-func createClientConfig(d *schema.ResourceData, remoteUser string, remotePassword string, remoteKeyEnc string) (*ssh.ClientConfig, error) {
+func createClientConfig(d *schema.ResourceData, remoteUser string, remotePassword string, remoteKeyEnc string, keyAttribute string) (*ssh.ClientConfig, error) {
 	var config *ssh.ClientConfig
 	if remoteKeyEnc != "" {
 		remoteKey, err := base64.StdEncoding.DecodeString(remoteKeyEnc)
 		if err != nil {
-			return nil, fmt.Errorf(ErrorMessage(d, "Error: error decoding private key. The private key must be base64 encoded", ""))
+			return nil, NewAttributeError(d, keyAttribute, "Error decoding private key", "The private key must be base64 encoded")
 		}
 		key, err := ssh.ParsePrivateKey([]byte(remoteKey))
 		if err != nil {
-			return nil, fmt.Errorf(ErrorMessage(d, "Error: error parsing private key:", err.Error()))
+			return nil, NewAttributeError(d, keyAttribute, "Error parsing private key", err.Error())
 		}
 		// Authentication
 		config = &ssh.ClientConfig{
@@ -260,7 +307,7 @@ func createClientConfig(d *schema.ResourceData, remoteUser string, remotePasswor
 			},
 		}
 	} else {
-		return nil, fmt.Errorf(ErrorMessage(d, "Error: one of user password or key is required when specifying remote_host or bastion_host", ""))
+		return nil, NewError(d, "One of user password or key is required when specifying remote_host or bastion_host", "")
 	}
 	return config, nil
 }
@@ -283,16 +330,18 @@ func BuildWgetCmd(d *schema.ResourceData, m interface{}, destination string) []s
 	return wgetCommand
 }
 
+// Returns the destination on the remote host. Commands and SFTP both start
+// in the home directory of remote_user, so a leading ~/ is dropped. The
+// commands that download to it quote it, and SFTP doesn't expand it either.
+func remoteDestination(d *schema.ResourceData) string {
+	return strings.TrimPrefix(d.Get("destination").(string), "~/")
+}
+
 // Helper function to transfer files from the local file system to a remote file system
-func TransferLocalToRemote(d *schema.ResourceData, m interface{}, localSource string) error {
-	destination := d.Get("destination").(string)
+func TransferLocalToRemote(ctx context.Context, d *schema.ResourceData, m interface{}, localSource string) error {
+	destination := remoteDestination(d)
 	remoteHost := d.Get("remote_host").(string)
-	var client *ssh.Client
 	var source string
-	var clienterr error
-	var sshConn ssh.Conn
-	var bastTohostConn net.Conn
-	var bastionclient *ssh.Client
 	if localSource == "" {
 		source = d.Get("source").(string)
 	} else {
@@ -302,58 +351,58 @@ func TransferLocalToRemote(d *schema.ResourceData, m interface{}, localSource st
 	if err != nil {
 		return err
 	}
-	bastionHost := d.Get("bastion_host").(string)
-	if bastionHost != "" {
-		client, bastionclient, bastTohostConn, sshConn, clienterr = getClientUsingBastionConn(d, config)
-		if clienterr != nil {
-			return fmt.Errorf(SubErrorMessage(d, "Error: error connecting to bastion host", clienterr.Error()))
-		}
-		defer client.Close()
-		defer bastionclient.Close()
-		defer bastTohostConn.Close()
-		defer sshConn.Close()
-	} else {
-		client, clienterr = ssh.Dial("tcp", remoteHost+":22", config)
-		if clienterr != nil {
-			return fmt.Errorf(ErrorMessage(d, "Error: error connecting to remote host", fmt.Sprintf("%s: %s", remoteHost, clienterr)))
-		}
+	client, closeClient, err := connectRemoteHost(ctx, d, m, config)
+	if err != nil {
+		return err
 	}
+	defer closeClient()
+	// abort the transfer if the operation is cancelled
+	stop := context.AfterFunc(ctx, closeClient)
+	defer stop()
+
 	// open an SFTP session over an existing ssh connection.
 	sftp, err := sftp.NewClient(client)
 	if err != nil {
-		return fmt.Errorf(ErrorMessage(d, "Error: error creating sftp client to host", fmt.Sprintf("%s: %s", remoteHost, err)))
+		return NewError(d, "Error creating sftp client to host", fmt.Sprintf("%s: %s", remoteHost, err))
 	}
 	defer sftp.Close()
 
 	// Open the source file
 	srcFile, err := os.Open(source)
 	if err != nil {
-		return fmt.Errorf(ErrorMessage(d, "Error: error opening local script", err.Error()))
+		return NewError(d, "Error opening local script", err.Error())
 	}
 	defer srcFile.Close()
 
 	// Create the destination file
+	TraceMessage(ctx, d, SubsystemSFTP, "Transferring file to remote host", map[string]interface{}{"host": remoteHost, "source": source, "destination": destination})
+	start := time.Now()
 	dstFile, err := sftp.Create(destination)
 	if err != nil {
-		return fmt.Errorf(ErrorMessage(d, "Error: error creating destination file on remote host:", err.Error()))
+		return NewAttributeError(d, "destination", "Error creating destination file on remote host", err.Error())
 	}
 	defer dstFile.Close()
 
 	buf := make([]byte, 1024)
+	var written int64
 	for {
 		n, _ := srcFile.Read(buf)
 		if n == 0 {
 			break
 		}
-		dstFile.Write(buf[:n])
+		if _, err := dstFile.Write(buf[:n]); err != nil {
+			return NewError(d, "Error writing destination file on remote host", err.Error())
+		}
+		written += int64(n)
 	}
+	TraceMessage(ctx, d, SubsystemSFTP, "Transferred file to remote host", map[string]interface{}{"host": remoteHost, "destination": destination, "bytes": written, "duration_ms": time.Since(start).Milliseconds()})
 	return nil
 }
 
 // Determines what commands are possible and downloads a file to a remote system.
-func DownloadRemoteFile(d *schema.ResourceData, m interface{}) error {
+func DownloadRemoteFile(ctx context.Context, d *schema.ResourceData, m interface{}) error {
 	source := d.Get("source").(string)
-	destination := d.Get("destination").(string)
+	destination := remoteDestination(d)
 	sourceUser := d.Get("source_user").(string)
 	sourcePass := d.Get("source_password").(string)
 	sourceNoCheckCert := d.Get("source_no_check_cert").(bool)
@@ -365,16 +414,16 @@ func DownloadRemoteFile(d *schema.ResourceData, m interface{}) error {
 		return err
 	}
 
-	_, err = RemoteExec(d, whichCmdWget, nil, config)
+	_, err = RemoteExec(ctx, d, m, whichCmdWget, nil, config, nil)
 	if err == nil {
 		wgetCommand := BuildWgetCmd(d, m, destination)
-		_, err := RemoteExec(d, wgetCommand, nil, config)
+		_, err := RemoteExec(ctx, d, m, wgetCommand, nil, config, nil)
 		return err
 	}
 
 	// if the remote system has curl, use curl
 	whichCmdCurl := []string{"which", "curl"}
-	_, err = RemoteExec(d, whichCmdCurl, nil, config)
+	_, err = RemoteExec(ctx, d, m, whichCmdCurl, nil, config, nil)
 	if err == nil {
 		var curlCommand []string
 		if strings.HasPrefix(source, "http://") {
@@ -385,7 +434,7 @@ func DownloadRemoteFile(d *schema.ResourceData, m interface{}) error {
 				curlCommand = append(curlCommand, "-k")
 			}
 		}
-		_, err := RemoteExec(d, curlCommand, nil, config)
+		_, err := RemoteExec(ctx, d, m, curlCommand, nil, config, nil)
 		return err
 	}
 
@@ -395,141 +444,118 @@ func DownloadRemoteFile(d *schema.ResourceData, m interface{}) error {
 	localSourceDir, err := ioutil.TempDir("/tmp", "")
 	localSourceFile := fmt.Sprintf("%s/%s", localSourceDir, localSource)
 	if err != nil {
-		return fmt.Errorf(ErrorMessage(d, fmt.Sprintf("Error: could not create temporary directory for source file"), err.Error()))
+		return NewError(d, "Could not create temporary directory for source file", err.Error())
 	}
 	wgetCommand := BuildWgetCmd(d, m, localSourceFile)
-	_, err = LocalExec(d, wgetCommand, nil)
+	_, err = LocalExec(ctx, d, wgetCommand, nil, nil)
 	if err != nil {
 		return err
 	}
-	err = TransferLocalToRemote(d, m, localSourceFile)
+	err = TransferLocalToRemote(ctx, d, m, localSourceFile)
 	os.Remove(localSourceFile)
 	return err
 }
 
+// Returns the destination of a local download. A relative one is in
+// working_dir, where relative programs are looked up and run.
+func localDestination(d *schema.ResourceData) string {
+	destination := d.Get("destination").(string)
+	if workingDir := d.Get("working_dir").(string); workingDir != "" && !filepath.IsAbs(destination) {
+		return filepath.Join(workingDir, destination)
+	}
+	return destination
+}
+
 // Determines if, how, and where to transfer the source script
-func HandleSourceAndDest(d *schema.ResourceData, m interface{}) error {
+func HandleSourceAndDest(ctx context.Context, d *schema.ResourceData, m interface{}) error {
 	source := d.Get("source").(string)
-	destination := d.Get("destination").(string)
 	remoteHost := d.Get("remote_host").(string)
-	sourceUser := d.Get("source_user").(string)
-	sourcePass := d.Get("source_password").(string)
 	sourceNoCheckCert := d.Get("source_no_check_cert").(bool)
 
-	if source != "" {
-		if destination == "" {
-			return fmt.Errorf(ErrorMessage(d, "Error: source was specified, but not destination", ""))
-		}
-		// If source is http(s), download file locally or remotely
-		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
-			if strings.Contains(source, ";") {
-				return fmt.Errorf(ErrorMessage(d, "Error: source contains illegal chracter: ", ";"))
-			}
-			if strings.HasPrefix(source, "https://") && (sourceUser == "" || sourcePass == "") {
-				return fmt.Errorf(ErrorMessage(d, "Error: the source_user and source_password properties are required when source is an https URL", ""))
-			}
-
-			if remoteHost != "" {
-				err := DownloadRemoteFile(d, m)
-				if err != nil {
-					return fmt.Errorf(ErrorMessage(d, "Error downloading source script", err.Error()))
-				}
-			} else {
-				if strings.HasPrefix(strings.TrimSpace(destination), "/") || strings.HasPrefix(strings.TrimSpace(destination), "~") {
-					return fmt.Errorf(ErrorMessage(d, "The destination parameter must be a relative path when downloading locally", ""))
-				}
-				if strings.Contains(destination, "..") {
-					return fmt.Errorf(ErrorMessage(d, "The destination parameter cannot contain reference to a parent directory", ""))
-				}
-				// Download locally using wget
-				wgetCommand := BuildWgetCmd(d, m, destination)
-				_, err := LocalExec(d, wgetCommand, nil)
-				if err != nil {
-					if !sourceNoCheckCert && strings.Contains(err.Error(), "no-check-certificate") {
-						return fmt.Errorf(ErrorMessage(d, fmt.Sprintf("Error: Could not verify the certificate for %s. Set the source_no_check_cert parameter to true if you want to ignore the certificate from the source URL.", source), err.Error()))
-					} else {
-						return fmt.Errorf(ErrorMessage(d, "Error downloading source script to local system", err.Error()))
-					}
-				}
-			}
-		} else {
-			// Local file verification
-			if _, err := os.Stat(source); os.IsNotExist(err) {
-				return fmt.Errorf(ErrorMessage(d, fmt.Sprintf("Error: can't find source program %q", source), ""))
+	// checked at plan time too, unless the values weren't known then
+	if err := checkSourceAndDest(d); err != nil {
+		return err
+	}
+	if source == "" {
+		return nil
+	}
+	// If source is http(s), download file locally or remotely
+	if isHTTPURL(source) {
+		if remoteHost != "" {
+			err := DownloadRemoteFile(ctx, d, m)
+			if err != nil {
+				return WrapError(d, "Error downloading source script", err)
 			}
-			if remoteHost != "" {
-				return TransferLocalToRemote(d, m, source)
+			return nil
+		}
+		// Download locally using wget
+		wgetCommand := BuildWgetCmd(d, m, localDestination(d))
+		_, err := LocalExec(ctx, d, wgetCommand, nil, nil)
+		if err != nil {
+			if !sourceNoCheckCert && strings.Contains(err.Error(), "no-check-certificate") {
+				return WrapError(d, fmt.Sprintf("Could not verify the certificate for %s. Set the source_no_check_cert parameter to true if you want to ignore the certificate from the source URL.", source), err)
 			} else {
-				return fmt.Errorf(ErrorMessage(d, "Error: copying a file from one directory to another on the provider container is not supported", ""))
+				return WrapError(d, "Error downloading source script to local system", err)
 			}
 		}
-	} else if destination != "" {
-		return fmt.Errorf(ErrorMessage(d, "Error: one of remote_password or remote_key is required when specifying remote_host", ""))
+		return nil
 	}
-	return nil
+	// Local file verification
+	if _, err := os.Stat(source); os.IsNotExist(err) {
+		return NewAttributeError(d, "source", fmt.Sprintf("Can't find source program %q", source), "")
+	}
+	return TransferLocalToRemote(ctx, d, m, source)
 }
 
 // Runs a script and returns the output and/or an error if it fails.
 // If the script returns JSON (recommended) it will be loaded into a map and returned
 // If the script returns a String, the String will be returned
-func RunScript(d *schema.ResourceData, m interface{}) (map[string]string, error) {
-	programI := d.Get("program").([]interface{})
-	programSens := d.Get("program_sensitive").([]interface{})
+func RunScript(ctx context.Context, d *schema.ResourceData, m interface{}) (map[string]string, diag.Diagnostics) {
+	ctx = LoggingContext(ctx, d)
 	query := d.Get("query").(map[string]interface{})
 	querySens := d.Get("query_sensitive").(map[string]interface{})
 	remote_host := d.Get("remote_host").(string)
 
-	if len(programI) < 1 && len(programSens) < 1 {
-		return nil, fmt.Errorf(ErrorMessage(d, "Error: program list must contain at least one element", ""))
-	}
-
-	for i, vI := range programI {
-		if _, ok := vI.(string); !ok {
-			return nil, fmt.Errorf(ErrorMessage(d, fmt.Sprintf("Error: program element %d is %T. a string is required", i, vI), ""))
-		}
-	}
-
-	for i, vI := range programSens {
-		if _, ok := vI.(string); !ok {
-			return nil, fmt.Errorf(ErrorMessage(d, fmt.Sprintf("Error: program_sensitive element %d is %T. a string is required", i, vI), ""))
-		}
+	program, err := getProgram(d)
+	if err != nil {
+		return nil, Diagnostics(err)
 	}
 
 	if remote_host != "" {
-		return RunRemoteScript(d, m)
+		return RunRemoteScript(ctx, d, m)
 	} else {
-		err := HandleSourceAndDest(d, m)
+		err := HandleSourceAndDest(ctx, d, m)
 		if err != nil {
-			return nil, err
+			return nil, Diagnostics(err)
 		}
 	}
 
-	// first element is assumed to be an executable command, possibly found
-	// using the PATH environment variable.
-	_, err := exec.LookPath(programI[0].(string))
+	opts, err := GetExecOptions(d)
 	if err != nil {
-		return nil, fmt.Errorf(ErrorMessage(d, fmt.Sprintf("Error: can't find external program %q", programI[0]), ""))
+		return nil, Diagnostics(err)
 	}
 
-	for i, v := range querySens {
-		query[i] = v
+	if opts.Become {
+		return nil, Diagnostics(NewAttributeError(d, "become", "become is only supported when specifying remote_host", ""))
 	}
 
-	// Merge the program and program_sensitive arrays
-	program := make([]string, len(programI)+len(programSens))
-	count := 0
-	for i, vI := range programI {
-		program[i] = vI.(string)
-		count++
+	// first element is assumed to be an executable command, possibly found
+	// using the PATH environment variable. A raw command is run by the shell
+	// so there's nothing to look up.
+	if !opts.RawCommand {
+		_, err = exec.LookPath(opts.localLookPath(program[0]))
+		if err != nil {
+			return nil, Diagnostics(NewAttributeError(d, "program", fmt.Sprintf("Can't find external program %q", program[0]), ""))
+		}
 	}
 
-	for i, vS := range programSens {
-		program[i+count] = vS.(string)
+	for i, v := range querySens {
+		query[i] = v
 	}
 
-	cmdOutput, err := LocalExec(d, program, query)
+	cmdOutput, err := LocalExec(ctx, d, program, query, opts)
 	if err != nil {
-		return nil, fmt.Errorf(ErrorMessage(d, "Error: error executing local program", err.Error()))
+		return nil, Diagnostics(WrapError(d, "Error executing local program", err))
 	}
 
 	var result map[string]string
@@ -539,7 +565,7 @@ func RunScript(d *schema.ResourceData, m interface{}) (map[string]string, error)
 		var tryTwo map[string]interface{}
 		err = json.Unmarshal(cmdOutput, &tryTwo)
 		if err == nil {
-			return nil, fmt.Errorf(ErrorMessage(d, fmt.Sprintf("Error: command %q produced JSON that was not key value pairs of strings, which is required by Terraform.", program[0]), ""))
+			return nil, Diagnostics(NewError(d, fmt.Sprintf("Command %q produced JSON that was not key value pairs of strings, which is required by Terraform.", program[0]), ""))
 		}
 		// The command did not return JSON, but it did return successfully. Return the response as a String
 		result = make(map[string]string)
@@ -548,69 +574,112 @@ func RunScript(d *schema.ResourceData, m interface{}) (map[string]string, error)
 	return result, nil
 }
 
+// Returns the elements of program followed by those of program_sensitive. The
+// SDK reads an empty string in a list as nil, which is an empty argument.
+func getProgram(d *schema.ResourceData) ([]string, error) {
+	var program []string
+	for _, attr := range []string{"program", "program_sensitive"} {
+		for i, v := range d.Get(attr).([]interface{}) {
+			if v == nil {
+				program = append(program, "")
+				continue
+			}
+			word, ok := v.(string)
+			if !ok {
+				return nil, NewAttributeError(d, attr, fmt.Sprintf("%s element %d is %T. a string is required", attr, i, v), "")
+			}
+			program = append(program, word)
+		}
+	}
+	if len(program) < 1 {
+		return nil, NewAttributeError(d, "program", "program list must contain at least one element", "")
+	}
+	return program, nil
+}
+
 // Contains the base function for executing a command locally. Helper method to RunScript
-func LocalExec(d *schema.ResourceData, program []string, query map[string]interface{}) ([]byte, error) {
-	cmd := exec.Command(program[0], program[1:]...)
+func LocalExec(ctx context.Context, d *schema.ResourceData, program []string, query map[string]interface{}, opts *ExecOptions) ([]byte, error) {
+	cmd := opts.localCommand(ctx, program)
+	if opts != nil {
+		cmd.Env = opts.localEnv()
+		cmd.Dir = opts.WorkingDir
+	}
 
 	queryJson, err := json.Marshal(query)
 	if err != nil {
 		// Should never happen, since we know query will always be a map
 		// from string to string, as guaranteed by d.Get and our schema.
-		return nil, fmt.Errorf(SubErrorMessage(d, "Error: error converting query JSON to map", err.Error()))
+		return nil, NewError(d, "Error converting query JSON to map", err.Error())
 	}
 
 	cmd.Stdin = bytes.NewReader(queryJson)
 
-	cmdOutput, err := cmd.Output()
+	// stream the output to the log while it's captured
+	stdout, stderr, transcript, err := opts.outputStreams(ctx, d, "local", program[0])
+	if err != nil {
+		return nil, NewAttributeError(d, "log_file", "Error opening log_file", err.Error())
+	}
+	defer transcript.Close()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	TraceMessage(ctx, d, SubsystemExec, "Running local program", map[string]interface{}{"program": program[0]})
+	start := time.Now()
+	err = cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	TraceMessage(ctx, d, SubsystemExec, "Local program finished", map[string]interface{}{"program": program[0], "exit_code": cmd.ProcessState.ExitCode(), "duration_ms": time.Since(start).Milliseconds()})
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if exitErr.Stderr != nil && len(exitErr.Stderr) > 0 {
-				return nil, fmt.Errorf(SubErrorMessage(d, fmt.Sprintf("Error: failed to execute %q", program[0]), string(exitErr.Stderr)))
+		if ctx.Err() != nil {
+			return nil, NewError(d, fmt.Sprintf("Execution of %q was cancelled", program[0]), ctx.Err().Error())
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			if len(stderr.Bytes()) > 0 {
+				return nil, NewError(d, fmt.Sprintf("Failed to execute %q", program[0]), string(stderr.Bytes()))
 			}
-			return nil, fmt.Errorf(SubErrorMessage(d, fmt.Sprintf("Error: command %q failed with no error message", program[0]), ""))
+			return nil, NewError(d, fmt.Sprintf("Command %q failed with no error message", program[0]), "")
 		} else {
-			return nil, fmt.Errorf(SubErrorMessage(d, fmt.Sprintf("Error: failed to execute %q", program[0]), err.Error()))
+			return nil, NewError(d, fmt.Sprintf("Failed to execute %q", program[0]), err.Error())
 		}
 	}
-	return cmdOutput, nil
+	return stdout.Bytes(), nil
 }
 
 // Transfers (if applicable) and executes a command or script on a remote system
-func RunRemoteScript(d *schema.ResourceData, m interface{}) (map[string]string, error) {
-	programI := d.Get("program").([]interface{})
-	programSens := d.Get("program_sensitive").([]interface{})
+func RunRemoteScript(ctx context.Context, d *schema.ResourceData, m interface{}) (map[string]string, diag.Diagnostics) {
 	query := d.Get("query").(map[string]interface{})
 	querySens := d.Get("query_sensitive").(map[string]interface{})
 
-	err := HandleSourceAndDest(d, m)
+	program, err := getProgram(d)
 	if err != nil {
-		return nil, err
+		return nil, Diagnostics(err)
 	}
 
-	config, err := CreateSSHConfig(d, m)
+	err = HandleSourceAndDest(ctx, d, m)
 	if err != nil {
-		return nil, err
+		return nil, Diagnostics(err)
 	}
 
-	for i, v := range querySens {
-		query[i] = v
+	config, err := CreateSSHConfig(d, m)
+	if err != nil {
+		return nil, Diagnostics(err)
 	}
 
-	// Merge the program and program_sensitive arrays
-	program := make([]string, len(programI)+len(programSens))
-	count := 0
-	for i, vI := range programI {
-		program[i] = vI.(string)
-		count++
+	opts, err := GetExecOptions(d)
+	if err != nil {
+		return nil, Diagnostics(err)
 	}
 
-	for i, vS := range programSens {
-		program[i+count] = vS.(string)
+	for i, v := range querySens {
+		query[i] = v
 	}
 
-	cmdOutput, err := RemoteExec(d, program, query, config)
+	cmdOutput, err := RemoteExec(ctx, d, m, program, query, config, opts)
 	if err != nil {
-		return nil, fmt.Errorf(ErrorMessage(d, "Error executing remote program", err.Error()))
+		return nil, Diagnostics(WrapError(d, "Error executing remote program", err))
+	}
+	if opts.RequestPty {
+		cmdOutput = ptyResult(cmdOutput)
 	}
 	var result map[string]string
 	err = json.Unmarshal(cmdOutput, &result)
@@ -619,7 +688,7 @@ func RunRemoteScript(d *schema.ResourceData, m interface{}) (map[string]string,
 		var tryTwo map[string]interface{}
 		err = json.Unmarshal(cmdOutput, &tryTwo)
 		if err == nil {
-			return nil, fmt.Errorf(ErrorMessage(d, fmt.Sprintf("Error: command %q produced JSON that was not key value pairs of strings, which is required by Terraform.", program[0]), ""))
+			return nil, Diagnostics(NewError(d, fmt.Sprintf("Command %q produced JSON that was not key value pairs of strings, which is required by Terraform.", program[0]), ""))
 		}
 		// The command did not return JSON, but it did return successfully. Return the response as a String
 		result = make(map[string]string)
@@ -628,96 +697,136 @@ func RunRemoteScript(d *schema.ResourceData, m interface{}) (map[string]string,
 	return result, nil
 }
 
-func getClientUsingBastionConn(d *schema.ResourceData, rmthostConfig *ssh.ClientConfig) (*ssh.Client, *ssh.Client, net.Conn, ssh.Conn, error) {
-	var bastionConfig *ssh.ClientConfig
-	var basterr error
+// Dials an ssh server through the ssh proxy of the provider, giving up when
+// the context is cancelled
+func dialSSH(ctx context.Context, m interface{}, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer, err := sshDialer(m)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// the handshake can hang too, so close the connection if the context ends first
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// Returns the address of remote_host, on the ssh port
+func remoteAddress(d *schema.ResourceData) string {
+	return d.Get("remote_host").(string) + ":22"
+}
+
+// Returns the address of bastion_host, on bastion_port or the ssh port
+func bastionAddress(d *schema.ResourceData) string {
+	bastionPort := d.Get("bastion_port").(string)
+	if bastionPort == "" {
+		bastionPort = "22"
+	}
+	return d.Get("bastion_host").(string) + ":" + bastionPort
+}
+
+// Connects to remote_host, through the bastion host if one is configured.
+// The returned function closes every connection that was opened and is safe
+// to call more than once.
+func connectRemoteHost(ctx context.Context, d *schema.ResourceData, m interface{}, config *ssh.ClientConfig) (*ssh.Client, func(), error) {
 	bastionHost := d.Get("bastion_host").(string)
+	remoteHost := d.Get("remote_host").(string)
+	var once sync.Once
 	if bastionHost != "" {
-		TraceMessage(d, fmt.Sprintf("Using bastion host %s to connect", bastionHost))
-		bastionPassword := d.Get("bastion_password").(string)
-		bastionPrivateKey := d.Get("bastion_private_key").(string)
-		bastionPort := d.Get("bastion_port").(string)
-		remoteHost := d.Get("remote_host").(string)
-		if bastionPort == "" {
-			bastionPort = "22"
-		}
-		if bastionPassword == "" && bastionPrivateKey == "" {
-			return nil, nil, nil, nil, fmt.Errorf(ErrorMessage(d, "Error: Bastion host password and private key is empty", "Provide value for bastion_password or bastion_private_key"))
-		}
-		bastionConfig, basterr = CreateBastionConfig(d)
-		if basterr != nil {
-			return nil, nil, nil, nil, fmt.Errorf(SubErrorMessage(d, "Error: error creating bastion ssh config", basterr.Error()))
-		}
-		bastionclient, err := ssh.Dial("tcp", bastionHost+":"+bastionPort, bastionConfig)
-		if err != nil {
-			return nil, nil, nil, nil, fmt.Errorf(SubErrorMessage(d, "Error: error creating bastion client", err.Error()))
-		}
-		//defer bastionclient.Close()
-		bastTohostConn, err := bastionclient.Dial("tcp", remoteHost+":22")
-		if err != nil {
-			return nil, nil, nil, nil, fmt.Errorf(SubErrorMessage(d, "Error: error creating connection to remote host using bastion client", err.Error()))
-		}
-		//defer bastTohostConn.Close()
-		sshConn, sshChan, req, err := ssh.NewClientConn(bastTohostConn, remoteHost, rmthostConfig)
+		start := time.Now()
+		client, bastionclient, bastTohostConn, sshConn, err := getClientUsingBastionConn(ctx, d, m, config)
 		if err != nil {
-			return nil, nil, nil, nil, fmt.Errorf(SubErrorMessage(d, "Error: error creating connection to remote host using bastion connection to remote host", err.Error()))
-		}
-		//defer sshConn.Close()
-		localToRemoteClient := ssh.NewClient(sshConn, sshChan, req)
-		return localToRemoteClient, bastionclient, bastTohostConn, sshConn, nil
-	} else {
-		return nil, nil, nil, nil, nil
+			return nil, nil, WrapError(d, "Error connecting using bastion host", err)
+		}
+		TraceMessage(ctx, d, SubsystemSSH, "Connected to remote host", map[string]interface{}{"host": remoteHost, "bastion_host": bastionHost, "duration_ms": time.Since(start).Milliseconds()})
+		return client, func() {
+			once.Do(func() {
+				client.Close()
+				sshConn.Close()
+				bastTohostConn.Close()
+				bastionclient.Close()
+			})
+		}, nil
+	}
+	start := time.Now()
+	client, err := dialSSH(ctx, m, remoteAddress(d), config)
+	if err != nil {
+		return nil, nil, NewAttributeError(d, "remote_host", "Error connecting to remote host", fmt.Sprintf("%s: %s", remoteHost, err))
 	}
+	TraceMessage(ctx, d, SubsystemSSH, "Connected to remote host", map[string]interface{}{"host": remoteHost, "duration_ms": time.Since(start).Milliseconds()})
+	return client, func() { once.Do(func() { client.Close() }) }, nil
 }
 
-// Contains the base function for executing a command remotely. Helper method to RunRemoteScript
-func RemoteExec(d *schema.ResourceData, program []string, query map[string]interface{}, config *ssh.ClientConfig) ([]byte, error) {
-	var session *ssh.Session
-	var sessionerr error
+func getClientUsingBastionConn(ctx context.Context, d *schema.ResourceData, m interface{}, rmthostConfig *ssh.ClientConfig) (*ssh.Client, *ssh.Client, net.Conn, ssh.Conn, error) {
+	var bastionConfig *ssh.ClientConfig
+	var basterr error
 	bastionHost := d.Get("bastion_host").(string)
+	TraceMessage(ctx, d, SubsystemSSH, "Using bastion host to connect", map[string]interface{}{"bastion_host": bastionHost})
+	bastionPassword := d.Get("bastion_password").(string)
+	bastionPrivateKey := d.Get("bastion_private_key").(string)
+	remoteHost := d.Get("remote_host").(string)
+	if bastionPassword == "" && bastionPrivateKey == "" && providerMeta(m).SSHPrivateKey == "" {
+		return nil, nil, nil, nil, NewAttributeError(d, "bastion_password", "Bastion host password and private key is empty", "Provide value for bastion_password or bastion_private_key")
+	}
+	bastionConfig, basterr = CreateBastionConfig(d, m)
+	if basterr != nil {
+		return nil, nil, nil, nil, basterr
+	}
+	bastionclient, err := dialSSH(ctx, m, bastionAddress(d), bastionConfig)
+	if err != nil {
+		return nil, nil, nil, nil, NewAttributeError(d, "bastion_host", "Error creating bastion client", err.Error())
+	}
+	bastTohostConn, err := bastionclient.DialContext(ctx, "tcp", remoteAddress(d))
+	if err != nil {
+		bastionclient.Close()
+		return nil, nil, nil, nil, NewAttributeError(d, "remote_host", "Error creating connection to remote host using bastion client", err.Error())
+	}
+	sshConn, sshChan, req, err := ssh.NewClientConn(bastTohostConn, remoteHost, rmthostConfig)
+	if err != nil {
+		bastTohostConn.Close()
+		bastionclient.Close()
+		return nil, nil, nil, nil, NewAttributeError(d, "remote_host", "Error creating connection to remote host using bastion connection to remote host", err.Error())
+	}
+	localToRemoteClient := ssh.NewClient(sshConn, sshChan, req)
+	return localToRemoteClient, bastionclient, bastTohostConn, sshConn, nil
+}
+
+// Contains the base function for executing a command remotely. Helper method to RunRemoteScript
+func RemoteExec(ctx context.Context, d *schema.ResourceData, m interface{}, program []string, query map[string]interface{}, config *ssh.ClientConfig, opts *ExecOptions) ([]byte, error) {
 	remoteHost := d.Get("remote_host").(string)
 	queryJson, err := json.Marshal(query)
 	if err != nil {
 		// Should never happen, since we know query will always be a map
 		// from string to string, as guaranteed by d.Get and our schema.
-		return nil, fmt.Errorf(SubErrorMessage(d, "Error: error converting query JSON to map", err.Error()))
+		return nil, NewError(d, "Error converting query JSON to map", err.Error())
 	}
-	var clientcp *ssh.Client
-	if bastionHost != "" {
-		localToRemoteClient, bastionclient, bastTohostConn, sshConn, err := getClientUsingBastionConn(d, config)
-		clientcp = localToRemoteClient
-		if err != nil {
-			return nil, fmt.Errorf(SubErrorMessage(d, "Error: error connecting using bastion host", err.Error()))
-		}
-		defer bastionclient.Close()
-		defer bastTohostConn.Close()
-		defer sshConn.Close()
-		if localToRemoteClient != nil {
-			defer localToRemoteClient.Close()
-			session, sessionerr = localToRemoteClient.NewSession()
-			if sessionerr != nil {
-				return nil, fmt.Errorf(SubErrorMessage(d, "Error: error creating new session to remote host using bastion host", sessionerr.Error()))
-			}
-			defer session.Close()
-		} else {
-			return nil, fmt.Errorf(ErrorMessage(d, "Error: error connecting using bastion host", ""))
-		}
-	} else {
-		// Connect
-		client, err := ssh.Dial("tcp", remoteHost+":22", config)
-		clientcp = client
-		if err != nil {
-			return nil, fmt.Errorf(SubErrorMessage(d, "Error: error creating remote client", err.Error()))
-		}
-		session, sessionerr = client.NewSession()
-		if sessionerr != nil {
-			return nil, fmt.Errorf(SubErrorMessage(d, "Error: error creating remote connection", sessionerr.Error()))
-		}
-		defer session.Close()
+	client, closeClient, err := connectRemoteHost(ctx, d, m, config)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+	opts, err = opts.forHost(ctx, d, client)
+	if err != nil {
+		return nil, NewAttributeError(d, "become", "Error checking whether sudo asks for a password", err.Error())
 	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, NewError(d, "Error creating remote session", err.Error())
+	}
+	defer session.Close()
+
 	//Create a context that will be used to send Done event to keepalive go routine
 	//when script execution is completed or results in error.
-	ctx, cancelKeepAlive := context.WithCancel(context.TODO())
+	keepAliveCtx, cancelKeepAlive := context.WithCancel(ctx)
+	defer cancelKeepAlive()
 	//go routine to send async ssh request to server every 15 seconds - mimics keepalive.
 	go func() {
 		t := time.NewTicker(15 * time.Second)
@@ -725,54 +834,57 @@ func RemoteExec(d *schema.ResourceData, program []string, query map[string]inter
 		for {
 			select {
 			case <-t.C:
-				//TraceMessage(d, fmt.Sprintf("Executing script ..."))
-				_, _, err := clientcp.SendRequest("keepalive@ibm.com", true, nil)
+				_, _, err := client.SendRequest("keepalive@ibm.com", true, nil)
 				if err != nil {
 					return
 				}
-			case <-ctx.Done():
+			case <-keepAliveCtx.Done():
 				return
 			}
 		}
 	}()
-	var b bytes.Buffer
-	session.Stdin = bytes.NewBufferString(string(queryJson[:]))
-	session.Stdout = &b                // get output
-	errpipe, _ := session.StderrPipe() // get error pipe
-	err = session.Run(strings.Join(program, " "))
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if exitErr.Stderr != nil && len(exitErr.Stderr) > 0 {
-				//Terminate keepalive routine by sending context cancel message to trigger ctx.Done.
-				cancelKeepAlive()
-				return nil, fmt.Errorf(SubErrorMessage(d, fmt.Sprintf("Error: failed to execute %q", program[0]), string(exitErr.Stderr)))
-			}
-			//Terminate keepalive routine by sending context cancel message to trigger ctx.Done.
-			cancelKeepAlive()
-			return nil, fmt.Errorf(SubErrorMessage(d, fmt.Sprintf("Error: command %q failed with no error message", program[0]), ""))
-		} else {
-			//Remote error exit throws ssh.ExitError.
-			//Get the error message from error pipe.
-			errout := ""
-			if errpipe != nil {
-				sc := bufio.NewScanner(errpipe)
-				var errbuffer bytes.Buffer
-				for sc.Scan() {
-					errbuffer.WriteString(sc.Text())
-				}
-				errbuffer.WriteString("\n")
-				errbuffer.WriteString(err.Error())
-				errout = errbuffer.String()
-			}
-			if errout == "" {
-				errout = err.Error()
-			}
-			//Terminate keepalive routine by sending context cancel message to trigger ctx.Done.
-			cancelKeepAlive()
-			return nil, fmt.Errorf(SubErrorMessage(d, fmt.Sprintf("Error: failed to execute %q", program[0]), errout))
+	// stream the output to the log while it's captured
+	stdout, stderr, transcript, err := opts.outputStreams(ctx, d, remoteHost, program[0])
+	if err != nil {
+		return nil, NewAttributeError(d, "log_file", "Error opening log_file", err.Error())
+	}
+	defer transcript.Close()
+	session.Stdin = opts.remoteStdin(queryJson)
+	session.Stdout = stdout
+	session.Stderr = stderr
+	command := ShellJoin(program)
+	if opts != nil {
+		command, err = opts.applyToSession(ctx, d, session, program, len(queryJson))
+		if err != nil {
+			return nil, NewAttributeError(d, "request_pty", "Error requesting a pseudo terminal", err.Error())
+		}
+	}
+	// stop the program and drop the connection if the operation is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		session.Signal(ssh.SIGTERM)
+		closeClient()
+	})
+	defer stop()
+	TraceMessage(ctx, d, SubsystemExec, "Running remote program", map[string]interface{}{"host": remoteHost, "program": program[0]})
+	start := time.Now()
+	err = session.Run(command)
+	stdout.Flush()
+	stderr.Flush()
+	TraceMessage(ctx, d, SubsystemExec, "Remote program finished", map[string]interface{}{"host": remoteHost, "program": program[0], "duration_ms": time.Since(start).Milliseconds()})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, NewError(d, fmt.Sprintf("Execution of %q was cancelled", program[0]), ctx.Err().Error())
+		}
+		//Remote error exit throws ssh.ExitError.
+		//Report what the command wrote to stderr along with it.
+		errout := err.Error()
+		if len(stderr.Bytes()) > 0 {
+			errout = strings.TrimSpace(string(stderr.Bytes())) + "\n" + errout
+		} else if opts != nil && opts.RequestPty && len(stdout.Bytes()) > 0 {
+			// with a pty the error message is in the combined output
+			errout = strings.TrimSpace(string(stdout.Bytes())) + "\n" + errout
 		}
+		return nil, NewError(d, fmt.Sprintf("Failed to execute %q", program[0]), errout)
 	}
-	//Terminate keepalive routine by sending context cancel message to trigger ctx.Done.
-	cancelKeepAlive()
-	return b.Bytes(), nil
+	return stdout.Bytes(), nil
 }
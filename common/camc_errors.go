@@ -0,0 +1,82 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"errors"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Error is returned by the helpers in this package. Summary says what failed,
+// Detail why, and Attribute names the argument at fault when there is one.
+// Diagnostics turns it into what a CRUD function returns.
+type Error struct {
+	Summary   string
+	Detail    string
+	Attribute string
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return e.Summary
+	}
+	return e.Summary + ": " + e.Detail
+}
+
+// Returns an Error with secrets redacted
+func NewError(d *schema.ResourceData, summary string, detail string) error {
+	return NewAttributeError(d, "", summary, detail)
+}
+
+// Like NewError for an error caused by the value of an attribute
+func NewAttributeError(d *schema.ResourceData, attribute string, summary string, detail string) error {
+	return &Error{
+		Summary:   Redact(d, summary),
+		Detail:    Redact(d, detail),
+		Attribute: attribute,
+	}
+}
+
+// Returns an Error for an operation that failed because of err. What err says
+// becomes the detail, and its attribute, if any, is kept.
+func WrapError(d *schema.ResourceData, summary string, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return &Error{Summary: Redact(d, summary), Detail: e.Error(), Attribute: e.Attribute}
+	}
+	return NewError(d, summary, err.Error())
+}
+
+// Converts an error returned by this package into diagnostics
+func Diagnostics(err error) diag.Diagnostics {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		return diag.FromErr(err)
+	}
+	diagnostic := diag.Diagnostic{
+		Severity: diag.Error,
+		Summary:  e.Summary,
+		Detail:   e.Detail,
+	}
+	if e.Attribute != "" {
+		diagnostic.AttributePath = cty.GetAttrPath(e.Attribute)
+	}
+	return diag.Diagnostics{diagnostic}
+}
+
+// Returns a warning diagnostic with secrets redacted
+func Warning(d *schema.ResourceData, summary string, detail string) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity: diag.Warning,
+		Summary:  Redact(d, summary),
+		Detail:   Redact(d, detail),
+	}
+}
@@ -0,0 +1,319 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var shellSafeRegexp = regexp.MustCompile(`^[A-Za-z0-9_@%+:,./-]+$`)
+
+// ExecOptions holds the per resource settings applied to the user's program
+// by LocalExec and RemoteExec. Helper commands (which, wget, curl) are run
+// with nil options so they are not affected by them.
+type ExecOptions struct {
+	Env            map[string]string
+	WorkingDir     string
+	RawCommand     bool
+	Become         bool
+	BecomeUser     string
+	BecomePassword string
+	LogFile        string
+	RequestPty     bool
+	PtyTerm        string
+	PtyWidth       int
+	PtyHeight      int
+}
+
+// Builds the ExecOptions from the environment, environment_sensitive,
+// working_dir, raw_command and become attributes. Sensitive values win over
+// plain ones with the same name.
+func GetExecOptions(d *schema.ResourceData) (*ExecOptions, error) {
+	opts := &ExecOptions{
+		Env:            make(map[string]string),
+		WorkingDir:     d.Get("working_dir").(string),
+		RawCommand:     d.Get("raw_command").(bool),
+		Become:         d.Get("become").(bool),
+		BecomeUser:     d.Get("become_user").(string),
+		BecomePassword: d.Get("become_password").(string),
+		LogFile:        d.Get("log_file").(string),
+		RequestPty:     d.Get("request_pty").(bool),
+		PtyTerm:        d.Get("pty_term").(string),
+		PtyWidth:       d.Get("pty_width").(int),
+		PtyHeight:      d.Get("pty_height").(int),
+	}
+	for _, attr := range []string{"environment", "environment_sensitive"} {
+		for k, v := range d.Get(attr).(map[string]interface{}) {
+			if !envNameRegexp.MatchString(k) {
+				return nil, NewAttributeError(d, attr, fmt.Sprintf("%s contains an invalid variable name %q", attr, k), "")
+			}
+			value, ok := v.(string)
+			if !ok {
+				return nil, NewAttributeError(d, attr, fmt.Sprintf("%s element %q is %T. a string is required", attr, k, v), "")
+			}
+			opts.Env[k] = value
+		}
+	}
+	return opts, nil
+}
+
+// Returns the environment names in a stable order
+func (opts *ExecOptions) envNames() []string {
+	names := make([]string, 0, len(opts.Env))
+	for k := range opts.Env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the environment for a local command: the provider environment
+// followed by the configured variables, which take precedence.
+func (opts *ExecOptions) localEnv() []string {
+	env := os.Environ()
+	for _, k := range opts.envNames() {
+		env = append(env, k+"="+opts.Env[k])
+	}
+	return env
+}
+
+// Returns the path exec.LookPath should check for a local program. Relative
+// paths are resolved against working_dir since that's where the command runs.
+func (opts *ExecOptions) localLookPath(program string) string {
+	if opts == nil || opts.WorkingDir == "" || filepath.IsAbs(program) || !strings.Contains(program, string(filepath.Separator)) {
+		return program
+	}
+	return filepath.Join(opts.WorkingDir, program)
+}
+
+// Returns the stdout and stderr streams for a program along with the
+// transcript they copy to, if log_file is set. Helper commands run with nil
+// options are only logged. With a pty, stdout carries both streams.
+func (opts *ExecOptions) outputStreams(ctx context.Context, d *schema.ResourceData, host string, program string) (*outputStream, *outputStream, *transcript, error) {
+	var t *transcript
+	stdoutName := "stdout"
+	if opts != nil {
+		var err error
+		t, err = openTranscript(opts.LogFile, Redact(d, host+" "+program))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if opts.RequestPty {
+			stdoutName = "pty"
+		}
+	}
+	ctx = tflog.SubsystemSetField(ctx, SubsystemExec, "resource_id", d.Id())
+	ctx = tflog.SubsystemSetField(ctx, SubsystemExec, "host", host)
+	ctx = tflog.SubsystemSetField(ctx, SubsystemExec, "program", program)
+	redact := func(line string) string {
+		return Redact(d, line)
+	}
+	return newOutputStream(ctx, stdoutName, t, redact), newOutputStream(ctx, "stderr", t, redact), t, nil
+}
+
+// Returns the command for a local program. With raw_command the elements are
+// joined and handed to /bin/sh, otherwise they are passed as-is as arguments.
+func (opts *ExecOptions) localCommand(ctx context.Context, program []string) *exec.Cmd {
+	if opts != nil && opts.RawCommand {
+		return exec.CommandContext(ctx, "/bin/sh", "-c", strings.Join(program, " "))
+	}
+	return exec.CommandContext(ctx, program[0], program[1:]...)
+}
+
+// Returns the command line for a remote program. The remote side always runs
+// the command through the user's shell, so unless raw_command is set every
+// element is quoted to give the same arguments a local program would get.
+func (opts *ExecOptions) remoteCommand(program []string) string {
+	if opts != nil && opts.RawCommand {
+		return strings.Join(program, " ")
+	}
+	return ShellJoin(program)
+}
+
+// Applies the options to a remote session and returns the command to run,
+// which is sent queryLen bytes of query on stdin.
+// Variables are sent with setenv requests. Most sshd configurations only accept
+// a few names (AcceptEnv), so if any is rejected they are exported by the
+// command itself instead. The working directory is always set with cd since
+// the ssh protocol has no equivalent request.
+func (opts *ExecOptions) applyToSession(ctx context.Context, d *schema.ResourceData, session *ssh.Session, program []string, queryLen int) (string, error) {
+	command := opts.remoteCommand(program)
+	if opts.RequestPty {
+		// The terminal is raw. A line of a terminal that isn't is limited to
+		// 4 KiB or so and its control characters are acted on, which would cut
+		// or change the query. Echo is turned off so the query and become
+		// password aren't copied to the output, and so is the CR the terminal
+		// adds to each line.
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          0,
+			ssh.ICANON:        0,
+			ssh.ISIG:          0,
+			ssh.IEXTEN:        0,
+			ssh.IXON:          0,
+			ssh.ICRNL:         0,
+			ssh.ONLCR:         0,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if err := session.RequestPty(opts.PtyTerm, opts.PtyHeight, opts.PtyWidth, modes); err != nil {
+			return "", err
+		}
+		// a raw terminal has no end of file, so the query is read by its
+		// length and piped to the program. dd reads a byte at a time since
+		// a read of a terminal can return less than was asked for.
+		if opts.RawCommand {
+			command = "{ " + command + "\n}"
+		}
+		command = fmt.Sprintf("dd bs=1 count=%d 2>/dev/null | %s", queryLen, command)
+	}
+	var prefix []string
+	names := opts.envNames()
+	// sudo resets the environment, so setenv is pointless when becoming another user
+	exportEnv := opts.Become && len(names) > 0
+	if !exportEnv {
+		for _, k := range names {
+			if err := session.Setenv(k, opts.Env[k]); err != nil {
+				TraceMessage(ctx, d, SubsystemSSH, "Remote host rejected setenv, exporting the environment in the command instead", map[string]interface{}{"name": k})
+				exportEnv = true
+				break
+			}
+		}
+	}
+	if exportEnv {
+		exports := make([]string, len(names))
+		for i, name := range names {
+			exports[i] = name + "=" + shellQuote(opts.Env[name])
+		}
+		prefix = append(prefix, "export "+strings.Join(exports, " ")+";")
+	}
+	if opts.WorkingDir != "" {
+		prefix = append(prefix, "cd "+shellQuote(opts.WorkingDir)+" || exit 1;")
+	}
+	if len(prefix) != 0 {
+		command = strings.Join(prefix, " ") + " " + command
+	}
+	if opts.Become {
+		command = opts.sudoCommand() + " /bin/sh -c " + shellQuote(command)
+	}
+	return command, nil
+}
+
+// Returns the sudo invocation used when become is set. With a password, sudo
+// is told to ignore cached credentials (-k) so it always reads the password
+// from the first line of stdin (-S) and never waits on a prompt. Without one
+// sudo must not prompt at all (-n).
+func (opts *ExecOptions) sudoCommand() string {
+	if opts.BecomePassword != "" {
+		return "sudo -k -S -p '' -u " + shellQuote(opts.becomeUser()) + " --"
+	}
+	return "sudo -n -u " + shellQuote(opts.becomeUser()) + " --"
+}
+
+// Returns become_user, root by default
+func (opts *ExecOptions) becomeUser() string {
+	if opts.BecomeUser == "" {
+		return "root"
+	}
+	return opts.BecomeUser
+}
+
+// Returns the options to run a program on client with. When sudo doesn't ask
+// the remote user for a password, such as with NOPASSWD, it would leave
+// become_password unread on stdin for the program, so it's dropped. Whether
+// it asks is checked by running true without a password in a session of its
+// own. Any other failure of sudo, such as an unknown become_user, is returned.
+func (opts *ExecOptions) forHost(ctx context.Context, d *schema.ResourceData, client *ssh.Client) (*ExecOptions, error) {
+	if opts == nil || !opts.Become || opts.BecomePassword == "" {
+		return opts, nil
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	// sudo's messages are translated otherwise
+	err = session.Run("LC_ALL=C sudo -n -u " + shellQuote(opts.becomeUser()) + " -- true")
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		if exitErr.ExitStatus() == 1 && strings.Contains(stderr.String(), "a password is required") {
+			return opts, nil
+		}
+		return nil, NewError(d, "sudo can't run programs as "+opts.becomeUser(), strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	TraceMessage(ctx, d, SubsystemExec, "sudo doesn't ask for a password, become_password isn't sent", map[string]interface{}{"become_user": opts.becomeUser()})
+	withoutPassword := *opts
+	withoutPassword.BecomePassword = ""
+	return &withoutPassword, nil
+}
+
+// Returns the stdin for a remote program. The become password, if any, is
+// sent ahead of the query JSON so it never shows up in the command line.
+func (opts *ExecOptions) remoteStdin(queryJson []byte) io.Reader {
+	var readers []io.Reader
+	if opts != nil && opts.Become && opts.BecomePassword != "" {
+		readers = append(readers, strings.NewReader(opts.BecomePassword+"\n"))
+	}
+	readers = append(readers, bytes.NewReader(queryJson))
+	return io.MultiReader(readers...)
+}
+
+// Returns the part of the output of a program run with a pty to parse as its
+// result. Anything the program writes to stderr ends up in the same stream,
+// so when the output as a whole isn't JSON the last line holding a JSON object
+// is used, letting scripts print progress before their result.
+func ptyResult(output []byte) []byte {
+	output = bytes.Replace(output, []byte("\r\n"), []byte("\n"), -1)
+	if json.Valid(output) {
+		return output
+	}
+	lines := bytes.Split(bytes.TrimSpace(output), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := bytes.TrimSpace(lines[i])
+		if bytes.HasPrefix(line, []byte("{")) && json.Valid(line) {
+			return line
+		}
+	}
+	return output
+}
+
+// Quotes each element of a program so a POSIX shell passes it through as a
+// single literal argument.
+func ShellJoin(program []string) string {
+	words := make([]string, len(program))
+	for i, word := range program {
+		words[i] = shellQuote(word)
+	}
+	return strings.Join(words, " ")
+}
+
+// Quotes a string so a POSIX shell treats it as a single literal word. Words
+// made only of characters that are never special are left as they are to keep
+// the command readable in traces.
+func shellQuote(s string) string {
+	if s != "" && shellSafeRegexp.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,47 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Logging subsystems of the provider. Their level follows TF_LOG_PROVIDER and
+// can be set separately with TF_LOG_PROVIDER_CAMC_HTTP, TF_LOG_PROVIDER_CAMC_SSH, ...
+const (
+	SubsystemHTTP = "http"
+	SubsystemSSH  = "ssh"
+	SubsystemSFTP = "sftp"
+	SubsystemExec = "exec"
+)
+
+var subsystems = []string{SubsystemHTTP, SubsystemSSH, SubsystemSFTP, SubsystemExec}
+
+// Returns ctx with the logging subsystems set up for the resource. They carry
+// the fields of the SDK, such as tf_resource_type. The values of its sensitive
+// attributes are masked in every message and field.
+func LoggingContext(ctx context.Context, d *schema.ResourceData) context.Context {
+	secrets := sensitiveValues(d)
+	for _, subsystem := range subsystems {
+		ctx = tflog.NewSubsystem(ctx, subsystem, tflog.WithLevelFromEnv("TF_LOG_PROVIDER_CAMC", strings.ToUpper(subsystem)), tflog.WithRootFields())
+		ctx = tflog.SubsystemMaskLogStrings(ctx, subsystem, secrets...)
+	}
+	return ctx
+}
+
+// Logs a message about the resource to a subsystem. Messages are logged at
+// debug level, or at info level when the resource has trace set so they can
+// be singled out with TF_LOG=INFO.
+func TraceMessage(ctx context.Context, d *schema.ResourceData, subsystem string, msg string, fields ...map[string]interface{}) {
+	if d.Get("trace").(bool) {
+		tflog.SubsystemInfo(ctx, subsystem, msg, fields...)
+	} else {
+		tflog.SubsystemDebug(ctx, subsystem, msg, fields...)
+	}
+}
@@ -0,0 +1,58 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+// ProviderMeta is what the provider passes to the resources as their meta
+type ProviderMeta struct {
+	// Sent as the User-Agent header of every pattern manager request
+	UserAgent string
+	// Obtains access tokens for resources that don't set access_token, or nil
+	// if the provider has no credentials
+	Tokens *TokenSource
+	// The HTTP clients of the pattern manager requests
+	Clients *ClientPool
+	// Added to every pattern manager request, before the headers of the
+	// resource
+	Headers          map[string]string
+	SensitiveHeaders map[string]string
+	// The proxies of the pattern manager requests and ssh connections, or nil
+	// to use the environment's
+	Proxy *ProxyConfig
+	// The URL that relative camc_endpoint values are resolved against
+	Endpoint string
+	// Sent by resources in bearer mode that don't set access_token
+	AccessToken string
+	// PEM encoded certificates trusted by every pattern manager resource
+	CABundle []byte
+	// Base64 encoded ssh key of the remote and bastion hosts that have no
+	// password or key of their own
+	SSHPrivateKey string
+	// Masked in what's logged or reported about every resource
+	SensitiveValues []string
+}
+
+// Returns the meta of the provider, or an empty one for resources used
+// without it
+func providerMeta(m interface{}) *ProviderMeta {
+	if meta, ok := m.(*ProviderMeta); ok {
+		return meta
+	}
+	return &ProviderMeta{}
+}
+
+// Returns the User-Agent header for requests made with the meta of a resource
+func UserAgent(m interface{}) string {
+	if meta, ok := m.(*ProviderMeta); ok && meta.UserAgent != "" {
+		return meta.UserAgent
+	}
+	return "terraform-provider-camc"
+}
+
+func providerTokens(m interface{}) *TokenSource {
+	if meta, ok := m.(*ProviderMeta); ok {
+		return meta.Tokens
+	}
+	return nil
+}
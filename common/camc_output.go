@@ -0,0 +1,104 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// outputStream captures the output of a program while writing each complete
+// line to the exec log subsystem, and to the transcript file if there is one,
+// as soon as it arrives. Long running scripts can then be followed with TF_LOG.
+// Lines are redacted before they leave the provider, the captured output isn't.
+type outputStream struct {
+	ctx        context.Context
+	stream     string
+	capture    bytes.Buffer
+	partial    []byte
+	transcript *transcript
+	redact     func(string) string
+}
+
+func newOutputStream(ctx context.Context, stream string, t *transcript, redact func(string) string) *outputStream {
+	return &outputStream{ctx: ctx, stream: stream, transcript: t, redact: redact}
+}
+
+func (s *outputStream) Write(p []byte) (int, error) {
+	s.capture.Write(p)
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		s.emit(string(bytes.TrimRight(s.partial[:i], "\r")))
+		s.partial = s.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Emits whatever is left after the last newline. Call once the program exits.
+func (s *outputStream) Flush() {
+	if len(s.partial) > 0 {
+		s.emit(string(bytes.TrimRight(s.partial, "\r")))
+		s.partial = nil
+	}
+}
+
+// Returns everything the program wrote to this stream
+func (s *outputStream) Bytes() []byte {
+	return s.capture.Bytes()
+}
+
+func (s *outputStream) emit(line string) {
+	line = s.redact(line)
+	tflog.SubsystemInfo(s.ctx, SubsystemExec, line, map[string]interface{}{"stream": s.stream})
+	s.transcript.writeLine(s.stream, line)
+}
+
+// transcript appends the output of a program to a local file. Stdout and
+// stderr are copied concurrently, so writes are serialized.
+type transcript struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Opens the transcript file named by log_file, or returns nil if it's not set.
+// The file is appended to so the output of every run is kept.
+func openTranscript(path string, label string) (*transcript, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	t := &transcript{file: file}
+	fmt.Fprintf(file, "=== %s %s ===\n", time.Now().Format(time.RFC3339), label)
+	return t, nil
+}
+
+func (t *transcript) writeLine(stream string, line string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.file, "%s [%s] %s\n", time.Now().Format(time.RFC3339), stream, line)
+}
+
+func (t *transcript) Close() {
+	if t == nil {
+		return
+	}
+	t.file.Close()
+}
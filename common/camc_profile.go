@@ -0,0 +1,92 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The profile file and profile read unless the provider sets others
+const (
+	DefaultConfigFile = "~/.camc/config"
+	DefaultProfile    = "default"
+)
+
+// Reads the profile named name from the file at path, a file of sections such
+// as
+//
+//	[default]
+//	endpoint     = https://cam.example.com:30000/cam/api/v1
+//	access_token = ...
+//
+// Lines starting with # or ; are comments. exists is false if there's no file
+// at path or it has no section for the profile.
+func ReadProfile(path string, name string) (profile map[string]string, exists bool, err error) {
+	path, err = ExpandHome(path)
+	if err != nil {
+		return nil, false, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	var section string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == name && !exists {
+				exists = true
+				profile = make(map[string]string)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			// don't quote the line, it may have a secret
+			return nil, false, fmt.Errorf("%s:%d: expected a [profile] or a key = value line", path, n)
+		}
+		if section == name {
+			profile[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	return profile, exists, nil
+}
+
+// Removes the quotes around a value, if it has them
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Replaces a leading ~ of path with the home directory of the user
+func ExpandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
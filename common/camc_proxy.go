@@ -0,0 +1,247 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyConfig routes the connections of the provider through proxies
+type ProxyConfig struct {
+	// URL of the proxy of the pattern manager requests. The HTTP_PROXY and
+	// HTTPS_PROXY variables are used if it isn't set.
+	HTTPProxy string
+	// Hosts that are connected to directly, as in the NO_PROXY variable
+	NoProxy string
+	// URL of the proxy of ssh connections, SOCKS5 or HTTP CONNECT
+	SSHProxy string
+	// Credentials of the proxies whose URL doesn't have them
+	Username string
+	Password string
+}
+
+// Proxy schemes of the pattern manager requests and of ssh connections
+var (
+	HTTPProxySchemes = []string{"http", "https", "socks5"}
+	SSHProxySchemes  = []string{"http", "https", "socks5", "socks5h"}
+)
+
+// Returns the proxy of the provider, or nil if it has none configured
+func ProviderProxy(m interface{}) *ProxyConfig {
+	if meta, ok := m.(*ProviderMeta); ok {
+		return meta.Proxy
+	}
+	return nil
+}
+
+// Parses the URL of a proxy, adding the credentials if it has none
+func (p *ProxyConfig) proxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		// the error quotes the URL, which may have a password
+		return nil, fmt.Errorf("invalid proxy URL")
+	}
+	if u.User == nil && p.Username != "" {
+		u.User = url.UserPassword(p.Username, p.Password)
+	}
+	return u, nil
+}
+
+// Returns the Proxy function of the transport of the pattern manager requests
+func (p *ProxyConfig) httpProxy() func(*http.Request) (*url.URL, error) {
+	if p == nil {
+		return http.ProxyFromEnvironment
+	}
+	config := httpproxy.FromEnvironment()
+	if p.HTTPProxy != "" {
+		config.HTTPProxy = p.HTTPProxy
+		config.HTTPSProxy = p.HTTPProxy
+	}
+	if p.NoProxy != "" {
+		config.NoProxy = p.NoProxy
+	}
+	proxyFunc := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := proxyFunc(req.URL)
+		if err != nil || u == nil {
+			return u, err
+		}
+		return p.proxyURL(u.String())
+	}
+}
+
+// A dialer that can be cancelled, as the dialers of x/net/proxy are
+type contextDialer interface {
+	proxy.Dialer
+	proxy.ContextDialer
+}
+
+// Returns the dialer of ssh connections, through ssh_proxy unless the host
+// is in no_proxy
+func sshDialer(m interface{}) (contextDialer, error) {
+	direct := &net.Dialer{}
+	p := ProviderProxy(m)
+	if p == nil || p.SSHProxy == "" {
+		return direct, nil
+	}
+	u, err := p.proxyURL(p.SSHProxy)
+	if err != nil {
+		return nil, err
+	}
+	var dialer contextDialer
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyAddress(u, "1080"), auth, direct)
+		if err != nil {
+			return nil, err
+		}
+		dialer = socks.(contextDialer)
+	case "http", "https":
+		dialer = &connectDialer{proxy: u, forward: direct}
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if p.NoProxy == "" {
+		return dialer, nil
+	}
+	perHost := proxy.NewPerHost(dialer, direct)
+	perHost.AddFromString(p.NoProxy)
+	return perHost, nil
+}
+
+// Returns the host and port of the proxy at u
+func proxyAddress(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// connectDialer opens connections through an HTTP proxy with CONNECT
+type connectDialer struct {
+	proxy   *url.URL
+	forward *net.Dialer
+}
+
+func (c *connectDialer) Dial(network, addr string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, addr)
+}
+
+func (c *connectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	defaultPort := "80"
+	if c.proxy.Scheme == "https" {
+		defaultPort = "443"
+	}
+	conn, err := c.forward.DialContext(ctx, "tcp", proxyAddress(c.proxy, defaultPort))
+	if err != nil {
+		return nil, err
+	}
+	// the proxy can hang too, so close the connection if the context ends first
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if c.proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: c.proxy.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if c.proxy.User != nil {
+		password, _ := c.proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(c.proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", c.proxy.Host, addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		// the server spoke first, keep what was read with the response
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what was buffered from a connection before the rest
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// Returns a function validating that a string attribute holds the URL of a
+// proxy with one of schemes. The URL isn't quoted, it may have a password.
+func ValidateProxyURL(schemes ...string) schema.SchemaValidateDiagFunc {
+	return func(i interface{}, path cty.Path) diag.Diagnostics {
+		v, ok := i.(string)
+		if !ok || v == "" {
+			return nil
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			return attributeDiagnostics(path, "Invalid proxy URL", "A URL such as http://proxy.example.com:3128 is expected.")
+		}
+		for _, scheme := range schemes {
+			if u.Scheme == scheme {
+				return nil
+			}
+		}
+		return attributeDiagnostics(path, fmt.Sprintf("Unsupported proxy scheme %q", u.Scheme),
+			fmt.Sprintf("The scheme must be one of %s.", strings.Join(schemes, ", ")))
+	}
+}
+
+// Returns the passwords of the proxy, to mask them in traces and errors
+func (p *ProxyConfig) Secrets() []string {
+	secrets := []string{p.Password}
+	for _, raw := range []string{p.HTTPProxy, p.SSHProxy} {
+		if u, err := url.Parse(raw); err == nil && u.User != nil {
+			if password, ok := u.User.Password(); ok {
+				secrets = append(secrets, password)
+			}
+		}
+	}
+	return secrets
+}
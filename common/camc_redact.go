@@ -0,0 +1,128 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Replaces secret values in messages
+const redactedValue = "******"
+
+// Values shorter than this are not redacted. They can't be meaningful
+// secrets and masking them would garble every message they appear in.
+const minRedactLength = 4
+
+// What's masked for a resource: the names of its sensitive attributes, with
+// their defaults, and the sensitive values of its provider
+type redaction struct {
+	names  map[string]interface{}
+	values []string
+}
+
+// The redactions of the resources whose operations are running, by their data
+var redactions = struct {
+	sync.RWMutex
+	data map[*schema.ResourceData]*redaction
+}{data: make(map[*schema.ResourceData]*redaction)}
+
+// Returns the attributes of a resource schema that are marked Sensitive, or
+// whose elements are, with their defaults
+func sensitiveAttributes(s map[string]*schema.Schema) map[string]interface{} {
+	names := make(map[string]interface{})
+	for name, attr := range s {
+		if attr.Sensitive {
+			names[name] = attr.Default
+		} else if elem, ok := attr.Elem.(*schema.Schema); ok && elem.Sensitive {
+			names[name] = attr.Default
+		}
+	}
+	return names
+}
+
+// Wraps the operations of r so that, while one runs, Redact masks the values
+// of r's sensitive attributes, unless they're the default such as the "null"
+// of data, along with the sensitive values of the provider. Called by the
+// provider for every resource it serves.
+func RedactResource(r *schema.Resource) *schema.Resource {
+	names := sensitiveAttributes(r.Schema)
+	wrap := func(op schema.CreateContextFunc) schema.CreateContextFunc {
+		if op == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+			redactions.Lock()
+			redactions.data[d] = &redaction{names: names, values: providerMeta(m).SensitiveValues}
+			redactions.Unlock()
+			defer func() {
+				redactions.Lock()
+				delete(redactions.data, d)
+				redactions.Unlock()
+			}()
+			return op(ctx, d, m)
+		}
+	}
+	r.CreateContext = wrap(r.CreateContext)
+	r.ReadContext = schema.ReadContextFunc(wrap(schema.CreateContextFunc(r.ReadContext)))
+	r.UpdateContext = schema.UpdateContextFunc(wrap(schema.CreateContextFunc(r.UpdateContext)))
+	r.DeleteContext = schema.DeleteContextFunc(wrap(schema.CreateContextFunc(r.DeleteContext)))
+	return r
+}
+
+// Returns the values of the sensitive attributes set on the resource and of
+// its provider, longest first so a secret containing another one is masked as
+// a whole. There are none outside the operations of a resource.
+func sensitiveValues(d *schema.ResourceData) []string {
+	redactions.RLock()
+	r, ok := redactions.data[d]
+	redactions.RUnlock()
+	if !ok {
+		return nil
+	}
+	var values []string
+	add := func(v interface{}) {
+		if s, ok := v.(string); ok && len(s) >= minRedactLength {
+			values = append(values, s)
+		}
+	}
+	for _, v := range r.values {
+		add(v)
+	}
+	for name, def := range r.names {
+		switch v := d.Get(name).(type) {
+		case string:
+			if v != def {
+				add(v)
+			}
+		case []interface{}:
+			for _, e := range v {
+				add(e)
+			}
+		case map[string]interface{}:
+			for _, e := range v {
+				add(e)
+			}
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	return values
+}
+
+// Masks the value of every sensitive attribute of the resource found in msg.
+// Everything the provider logs or reports about a resource goes through here.
+func Redact(d *schema.ResourceData, msg string) string {
+	for _, v := range sensitiveValues(d) {
+		msg = strings.Replace(msg, v, redactedValue, -1)
+	}
+	return msg
+}
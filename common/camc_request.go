@@ -0,0 +1,226 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/net/http/httpguts"
+)
+
+// Content type of the pattern manager requests unless content_type is set
+const DefaultContentType = "application/json"
+
+// Headers that headers and sensitive_headers can't set, the provider sets
+// them from other attributes
+var reservedHeaders = map[string]string{
+	"Authorization":  "auth_mode",
+	"Content-Type":   "content_type",
+	"Content-Length": "data",
+	"Host":           "camc_endpoint",
+}
+
+// Returns the URL of camc_endpoint. A relative one is resolved against the
+// endpoint of the provider, as a directory: bootstrap is under its path,
+// /bootstrap at the root of its host.
+func patternManagerURL(c configReader, m interface{}) (string, error) {
+	endpoint := c.Get("camc_endpoint").(string)
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.IsAbs() {
+		return endpoint, nil
+	}
+	base := providerMeta(m).Endpoint
+	if base == "" {
+		return "", fmt.Errorf("%q is a relative URL and the provider has no endpoint to resolve it against", endpoint)
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(b.Path, "/") {
+		b.Path += "/"
+	}
+	return b.ResolveReference(u).String(), nil
+}
+
+// Checks at plan time that camc_endpoint is a URL the request can be sent to
+func checkEndpoint(c configReader, m interface{}) error {
+	if !isKnown(c, "camc_endpoint") {
+		return nil
+	}
+	if _, err := patternManagerURL(c, m); err != nil {
+		return &Error{Summary: "Invalid camc_endpoint", Detail: err.Error(), Attribute: "camc_endpoint"}
+	}
+	return nil
+}
+
+// Returns the headers and sensitive_headers of the provider and of the
+// resource merged, the resource's replacing the provider's
+func requestHeaders(d *schema.ResourceData, m interface{}) http.Header {
+	header := make(http.Header)
+	add := func(headers map[string]string) {
+		for k, v := range headers {
+			header.Set(k, v)
+		}
+	}
+	if meta, ok := m.(*ProviderMeta); ok {
+		add(meta.Headers)
+		add(meta.SensitiveHeaders)
+	}
+	add(StringMap(d.Get("headers")))
+	add(StringMap(d.Get("sensitive_headers")))
+	return header
+}
+
+// Returns the names of header, for logging without the values
+func headerNames(header http.Header) []string {
+	names := make([]string, 0, len(header))
+	for k := range header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Converts the value of a map attribute of strings
+func StringMap(v interface{}) map[string]string {
+	m, _ := v.(map[string]interface{})
+	result := make(map[string]string, len(m))
+	for k, e := range m {
+		if s, ok := e.(string); ok {
+			result[k] = s
+		}
+	}
+	return result
+}
+
+// Validates that the keys and values of a map attribute can be sent as HTTP
+// headers, and that the headers aren't set from other attributes
+func ValidateHeaders(i interface{}, path cty.Path) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for k, v := range i.(map[string]interface{}) {
+		if !httpguts.ValidHeaderFieldName(k) {
+			diags = append(diags, attributeDiagnostics(path, fmt.Sprintf("Invalid header name %q", k), "")...)
+			continue
+		}
+		if attribute, ok := reservedHeaders[http.CanonicalHeaderKey(k)]; ok {
+			diags = append(diags, attributeDiagnostics(path, fmt.Sprintf("The %s header can't be set", k),
+				fmt.Sprintf("It's set from %s.", attribute))...)
+			continue
+		}
+		if s, ok := v.(string); ok && !httpguts.ValidHeaderFieldValue(s) {
+			// don't echo the value, it may be a secret
+			diags = append(diags, attributeDiagnostics(path, fmt.Sprintf("Invalid value of the %s header", k),
+				"Header values can't contain control characters such as newlines.")...)
+		}
+	}
+	return diags
+}
+
+// Validates that a string attribute holds a media type
+func ValidateContentType(i interface{}, path cty.Path) diag.Diagnostics {
+	v, ok := i.(string)
+	if !ok || v == "" {
+		return nil
+	}
+	if _, _, err := mime.ParseMediaType(v); err != nil {
+		return attributeDiagnostics(path, fmt.Sprintf("Invalid content type %q", v), err.Error())
+	}
+	return nil
+}
+
+// Reports whether contentType is JSON, application/json or a +json type
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Encodes the JSON document body as contentType. JSON is sent as it is, the
+// members of an object as a form, and a string as the body itself, for
+// content such as YAML.
+func encodeBody(body []byte, contentType string) ([]byte, error) {
+	if body == nil || contentType == "" || isJSONContentType(contentType) {
+		return body, nil
+	}
+	doc, err := decodeJSON(string(body))
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		return encodeForm(doc)
+	}
+	s, ok := doc.(string)
+	if !ok {
+		return nil, fmt.Errorf("a JSON string is expected to send as %s, such as jsonencode(file(...))", mediaType)
+	}
+	return []byte(s), nil
+}
+
+// Encodes a JSON object as a form. Arrays become repeated fields.
+func encodeForm(doc interface{}) ([]byte, error) {
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("a JSON object is expected to send as a form")
+	}
+	form := url.Values{}
+	scalar := func(k string, v interface{}) error {
+		switch v := v.(type) {
+		case nil:
+			form.Add(k, "")
+		case string:
+			form.Add(k, v)
+		case json.Number:
+			form.Add(k, v.String())
+		case bool:
+			form.Add(k, fmt.Sprint(v))
+		default:
+			return fmt.Errorf("%s can't be sent as a form field, only strings, numbers, booleans and arrays of them can", k)
+		}
+		return nil
+	}
+	for k, v := range object {
+		values, ok := v.([]interface{})
+		if !ok {
+			values = []interface{}{v}
+		}
+		for _, e := range values {
+			if err := scalar(k, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return []byte(form.Encode()), nil
+}
+
+// Checks at plan time that data can be sent as content_type
+func checkContentType(c configReader) error {
+	if !isKnown(c, "data", "content_type") {
+		return nil
+	}
+	data := c.Get("data").(string)
+	if data == "" || data == "null" || IsDataHash(data) {
+		return nil
+	}
+	if _, err := encodeBody([]byte(data), c.Get("content_type").(string)); err != nil {
+		return &Error{Summary: "data can't be sent as " + c.Get("content_type").(string), Detail: err.Error(), Attribute: "data"}
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Response is a successful response from the pattern manager
+type Response struct {
+	StatusCode int
+	Body       string
+}
+
+// Saves resp as the response and status_code of a pattern manager resource
+// and extracts its outputs. A JSON body is saved normalized, so formatting
+// changes on the server side don't show up as differences.
+func SetResponse(d *schema.ResourceData, resp *Response) diag.Diagnostics {
+	d.Set("status_code", resp.StatusCode)
+	d.Set("response", normalizeJSON(resp.Body))
+	return SetOutputs(d)
+}
+
+// Sets outputs to the values that the JSON paths in output_paths select from
+// the saved response. A path that selects nothing is left out with a warning,
+// the request has been made by then and failing would only repeat it.
+func SetOutputs(d *schema.ResourceData) diag.Diagnostics {
+	var diags diag.Diagnostics
+	paths := d.Get("output_paths").(map[string]interface{})
+	outputs := make(map[string]interface{}, len(paths))
+	if len(paths) == 0 {
+		d.Set("outputs", outputs)
+		return nil
+	}
+
+	doc, err := decodeJSON(d.Get("response").(string))
+	if err != nil {
+		d.Set("outputs", outputs)
+		return append(diags, Warning(d, "The response from the pattern manager is not JSON",
+			"None of output_paths can be extracted from it."))
+	}
+	for name, path := range paths {
+		value, err := EvalJSONPath(doc, path.(string))
+		if err != nil {
+			diags = append(diags, Warning(d, fmt.Sprintf("Output %q was not found in the response", name), err.Error()))
+			continue
+		}
+		outputs[name] = jsonString(value)
+	}
+	d.Set("outputs", outputs)
+	return diags
+}
+
+// Evaluates a JSON path such as $.items[0].id against a decoded JSON
+// document. The leading $ is optional, members are selected with .name and
+// array elements with [index].
+func EvalJSONPath(doc interface{}, path string) (interface{}, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	value := doc
+	for i, step := range steps {
+		switch s := step.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an object", jsonPathPrefix(steps[:i]))
+			}
+			if value, ok = object[s]; !ok {
+				return nil, fmt.Errorf("%s has no member %q", jsonPathPrefix(steps[:i]), s)
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an array", jsonPathPrefix(steps[:i]))
+			}
+			if s >= len(array) {
+				return nil, fmt.Errorf("%s has %d elements", jsonPathPrefix(steps[:i]), len(array))
+			}
+			value = array[s]
+		}
+	}
+	return value, nil
+}
+
+// Splits a JSON path into member names and array indexes
+func parseJSONPath(path string) ([]interface{}, error) {
+	var steps []interface{}
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	if rest != "" && rest[0] != '.' && rest[0] != '[' {
+		rest = "." + rest
+	}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, fmt.Errorf("empty member name in %q", path)
+			}
+			steps = append(steps, rest[1:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in %q", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid array index %q in %q", rest[1:end], path)
+			}
+			steps = append(steps, index)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("expected . or [ at %q in %q", rest, path)
+		}
+	}
+	return steps, nil
+}
+
+func jsonPathPrefix(steps []interface{}) string {
+	prefix := "$"
+	for _, step := range steps {
+		if index, ok := step.(int); ok {
+			prefix += fmt.Sprintf("[%d]", index)
+		} else {
+			prefix += "." + step.(string)
+		}
+	}
+	return prefix
+}
+
+// Returns a string as it is and any other JSON value encoded
+func jsonString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	s, _ := encodeJSON(value)
+	return s
+}
+
+// Returns body re-encoded with sorted keys and no whitespace, or as it is if
+// it isn't JSON
+func normalizeJSON(body string) string {
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return body
+	}
+	s, err := encodeJSON(doc)
+	if err != nil {
+		return body
+	}
+	return s
+}
+
+// Like json.Marshal, leaving <, > and & as they are
+func encodeJSON(value interface{}) (string, error) {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// Decodes a JSON document, keeping numbers as they are written so large ids
+// don't lose precision
+func decodeJSON(s string) (interface{}, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return doc, nil
+}
+
+// Validates that the values of a map attribute are JSON paths
+func ValidateJSONPaths(i interface{}, path cty.Path) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for k, v := range i.(map[string]interface{}) {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if _, err := parseJSONPath(s); err != nil {
+			diags = append(diags, attributeDiagnostics(path.IndexString(k), "Invalid JSON path", err.Error())...)
+		}
+	}
+	return diags
+}
+
+// Headers that servers and gateways identify a request with, to quote when
+// reporting a problem
+var requestIDHeaders = []string{"X-Request-Id", "X-Correlation-Id", "X-Transaction-Id", "X-Global-Transaction-Id", "Request-Id"}
+
+// Longest response body quoted in an error
+const maxErrorBodyLength = 1024
+
+// Returns the request id of a response, or "" if it has none
+func requestID(resp *http.Response) string {
+	for _, header := range requestIDHeaders {
+		if v := resp.Header.Get(header); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Returns the error for a response with a status that isn't a success. The
+// code, message and details of a JSON error are picked out of the body, any
+// other body is quoted, shortened.
+func ResponseError(d *schema.ResourceData, resp *http.Response, body string) error {
+	path := resp.Request.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	summary := fmt.Sprintf("%s %s returned %s", resp.Request.Method, path, resp.Status)
+	var detail []string
+	if code, message, details, ok := parseErrorBody(body); ok {
+		if message != "" {
+			summary += ": " + message
+		}
+		if code != "" {
+			detail = append(detail, "Code: "+code)
+		}
+		if details != "" {
+			detail = append(detail, "Details: "+details)
+		}
+	} else if text := errorBodyText(resp, body); text != "" {
+		detail = append(detail, "Response: "+text)
+	}
+	if id := requestID(resp); id != "" {
+		detail = append(detail, "Request ID: "+id)
+	}
+	return NewError(d, summary, strings.Join(detail, "\n"))
+}
+
+// Picks the code, message and details out of a JSON error. Besides the code,
+// message and details of CAM, the error and errors members other services
+// use are understood.
+func parseErrorBody(body string) (string, string, string, bool) {
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return "", "", "", false
+	}
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return "", "", "", false
+	}
+	// the error may be nested, {"error": {...}} or {"errors": [{...}, ...]}
+	if nested, ok := object["error"].(map[string]interface{}); ok {
+		object = nested
+	} else if list, ok := object["errors"].([]interface{}); ok && len(list) > 0 {
+		if first, ok := list[0].(map[string]interface{}); ok {
+			object = first
+		}
+	}
+	member := func(keys ...string) string {
+		for _, key := range keys {
+			if v, ok := object[key]; ok && v != nil {
+				return jsonString(v)
+			}
+		}
+		return ""
+	}
+	code := member("code", "errorCode", "error_code")
+	message := member("message", "msg", "error_description", "description", "error", "reason")
+	details := member("details", "detail", "more_info")
+	if code == "" && message == "" && details == "" {
+		return "", "", "", false
+	}
+	return code, message, details, true
+}
+
+// Returns a body as text for an error: the text of HTML without the markup,
+// with the whitespace collapsed, and shortened
+func errorBodyText(resp *http.Response, body string) string {
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") || strings.HasPrefix(strings.TrimSpace(body), "<") {
+		body = htmlTagRegexp.ReplaceAllString(htmlScriptRegexp.ReplaceAllString(body, " "), " ")
+		body = html.UnescapeString(body)
+	}
+	text := strings.Join(strings.Fields(body), " ")
+	if len(text) > maxErrorBodyLength {
+		n := maxErrorBodyLength
+		for n > 0 && !utf8.RuneStart(text[n]) {
+			n--
+		}
+		text = fmt.Sprintf("%s... (%d more bytes)", text[:n], len(text)-n)
+	}
+	return text
+}
+
+var (
+	htmlScriptRegexp = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)>`)
+	htmlTagRegexp    = regexp.MustCompile(`<[^>]*>`)
+)
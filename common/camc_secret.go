@@ -0,0 +1,123 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Prefix of the salted hashes saved in place of data when hash_data is set
+const dataHashPrefix = "hmac-sha256:"
+
+// Returns a salted hash of a JSON document, saved in state in its place so
+// changes can be detected without keeping the document. Documents that are
+// equivalent as JSON hash the same.
+func HashData(data string) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	return dataHashPrefix + hex.EncodeToString(salt) + ":" + hashDataWithSalt(salt, data)
+}
+
+func hashDataWithSalt(salt []byte, data string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(canonicalJSON(data)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Reports whether a value of data in state is a hash from HashData
+func IsDataHash(value string) bool {
+	return strings.HasPrefix(value, dataHashPrefix)
+}
+
+// Reports whether data is the document that hash was made from
+func dataHashMatches(hash string, data string) bool {
+	salt, sum, ok := strings.Cut(strings.TrimPrefix(hash, dataHashPrefix), ":")
+	if !ok {
+		return false
+	}
+	saltBytes, err := hex.DecodeString(salt)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(sum), []byte(hashDataWithSalt(saltBytes, data)))
+}
+
+// Returns a JSON document with sorted keys, no whitespace and numbers written
+// as fractions, so equivalent documents are the same string
+func canonicalJSON(data string) string {
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return data
+	}
+	var canonical func(interface{}) interface{}
+	canonical = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, w := range v {
+				v[k] = canonical(w)
+			}
+		case []interface{}:
+			for i, w := range v {
+				v[i] = canonical(w)
+			}
+		case json.Number:
+			if r, ok := new(big.Rat).SetString(v.String()); ok {
+				return r.RatString()
+			}
+		}
+		return v
+	}
+	s, err := encodeJSON(canonical(doc))
+	if err != nil {
+		return data
+	}
+	return s
+}
+
+// DiffSuppressFunc of a data attribute that may hold a hash from HashData in
+// state. The hash is compared with the configured document, otherwise the
+// documents are compared as JSON.
+func SuppressEquivalentData(k, old, new string, d *schema.ResourceData) bool {
+	if IsDataHash(old) {
+		return dataHashMatches(old, new)
+	}
+	return SuppressEquivalentJSON(k, old, new, d)
+}
+
+// Saves data in state as hash_data asks, after it has been sent: a hash of
+// the configured document if it's set, the document itself otherwise.
+func SetData(d *schema.ResourceData) {
+	data := d.Get("data").(string)
+	// once data is hashed, the document is only in the configuration
+	if v, ok := configString(d, "data"); ok {
+		data = v
+	}
+	if IsDataHash(data) {
+		return
+	}
+	if d.Get("hash_data").(bool) && data != "null" {
+		data = HashData(data)
+	}
+	d.Set("data", data)
+}
+
+// Returns the value of a string attribute from the configuration. Write-only
+// attributes can only be read this way, they're never saved in state.
+func configString(d *schema.ResourceData, key string) (string, bool) {
+	v, diags := d.GetRawConfigAt(cty.GetAttrPath(key))
+	if diags.HasError() || v.IsNull() || !v.IsKnown() || !v.Type().Equals(cty.String) {
+		return "", false
+	}
+	return v.AsString(), true
+}
@@ -0,0 +1,199 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// The operations of a resource that expected_status_codes can be set for
+const (
+	OperationCreate = "create"
+	OperationRead   = "read"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Status codes that are a success unless expected_status_codes says otherwise
+var DefaultStatusCodes = []int{http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent}
+
+// How long to wait between polls of an operation that's still running when
+// the server doesn't say with Retry-After
+const pollInterval = 5 * time.Second
+
+// Returns the schema of expected_status_codes, with a list of status codes for
+// each of operations
+func ExpectedStatusCodesSchema(operations ...string) *schema.Schema {
+	codes := make(map[string]*schema.Schema, len(operations))
+	for _, operation := range operations {
+		codes[operation] = &schema.Schema{
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem: &schema.Schema{
+				Type:         schema.TypeInt,
+				ValidateFunc: validation.IntBetween(100, 599),
+			},
+		}
+	}
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem:     &schema.Resource{Schema: codes},
+	}
+}
+
+// Returns the operation that a pattern manager request with method makes
+func OperationOf(method string) string {
+	switch method {
+	case http.MethodPost:
+		return OperationCreate
+	case http.MethodGet:
+		return OperationRead
+	case http.MethodDelete:
+		return OperationDelete
+	}
+	return OperationUpdate
+}
+
+// Reports whether code is a success for operation, as set in
+// expected_status_codes or by DefaultStatusCodes
+func ExpectedStatus(d *schema.ResourceData, operation string, code int) bool {
+	expected := DefaultStatusCodes
+	if blocks, ok := d.Get("expected_status_codes").([]interface{}); ok && len(blocks) > 0 && blocks[0] != nil {
+		if codes, ok := blocks[0].(map[string]interface{})[operation].([]interface{}); ok && len(codes) > 0 {
+			expected = nil
+			for _, c := range codes {
+				expected = append(expected, c.(int))
+			}
+		}
+	}
+	for _, c := range expected {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a delete found the resource already gone
+func IsGone(operation string, code int) bool {
+	return operation == OperationDelete && (code == http.StatusNotFound || code == http.StatusGone)
+}
+
+// Returns the timeout of operation
+func OperationTimeout(d *schema.ResourceData, operation string) time.Duration {
+	switch operation {
+	case OperationCreate:
+		return d.Timeout(schema.TimeoutCreate)
+	case OperationRead:
+		return d.Timeout(schema.TimeoutRead)
+	case OperationDelete:
+		return d.Timeout(schema.TimeoutDelete)
+	}
+	return d.Timeout(schema.TimeoutUpdate)
+}
+
+// Returns the URL to poll for the outcome of an operation accepted with
+// 202, or "" if the response doesn't give one
+func operationLocation(resp *http.Response) string {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		location = resp.Header.Get("Operation-Location")
+	}
+	if location == "" {
+		return ""
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return ""
+	}
+	return resp.Request.URL.ResolveReference(u).String()
+}
+
+// Reports whether the URLs a and b have the same scheme, host and port, so a
+// request to a can be sent the credentials of b
+func sameOrigin(a string, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Hostname(), ub.Hostname()) && originPort(ua) == originPort(ub)
+}
+
+// Returns the port of u, the default one of its scheme if it has none
+func originPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		return "443"
+	case "http":
+		return "80"
+	}
+	return ""
+}
+
+// Returns how long the server asks to wait before polling again
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return pollInterval
+}
+
+// PollFunc sends a GET to url and returns the response with its body read
+type PollFunc func(url string) (*http.Response, string, error)
+
+// Polls the operation that resp accepted until it's no longer 202 Accepted,
+// and returns the last response, which must have a status that's expected for
+// operation. A response with no URL to poll is returned as it is, the server
+// accepted the request and doesn't say more.
+func PollOperation(ctx context.Context, d *schema.ResourceData, operation string, resp *http.Response, body string, poll PollFunc) (*http.Response, string, error) {
+	deadline := time.Now().Add(OperationTimeout(d, operation))
+	for resp.StatusCode == http.StatusAccepted {
+		location := operationLocation(resp)
+		if location == "" {
+			return resp, body, nil
+		}
+		wait := retryAfter(resp)
+		if time.Now().Add(wait).After(deadline) {
+			return nil, "", NewError(d, "Timed out waiting for the pattern manager",
+				fmt.Sprintf("The %s operation at %s was still running after %s.", operation, location, OperationTimeout(d, operation)))
+		}
+		TraceMessage(ctx, d, SubsystemHTTP, "Waiting for the operation to finish", map[string]interface{}{"url": location, "wait_ms": wait.Milliseconds()})
+		select {
+		case <-ctx.Done():
+			return nil, "", NewError(d, "Stopped waiting for the pattern manager", ctx.Err().Error())
+		case <-time.After(wait):
+		}
+		var err error
+		if resp, body, err = poll(location); err != nil {
+			return nil, "", err
+		}
+		if resp.StatusCode != http.StatusAccepted && !ExpectedStatus(d, operation, resp.StatusCode) {
+			return nil, "", WrapError(d, "The "+operation+" operation failed", ResponseError(d, resp, body))
+		}
+	}
+	return resp, body, nil
+}
@@ -0,0 +1,178 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// TLS versions accepted by min_tls_version
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Returns the TLS configuration of a resource. Each option applies on its
+// own: the CA bundle in ca_file and ca_pem is trusted along with the system
+// roots, and the client certificate comes from cert_file and key_file or from
+// cert_pem and key_pem. The CA bundle of the provider is trusted by every
+// resource. Options the resource doesn't have are left unset, camc only has
+// the files.
+func tlsClientConfig(d *schema.ResourceData, m interface{}) (*tls.Config, error) {
+	skipVerify, _ := d.Get("skip_ssl_verify").(bool)
+	config := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+		ServerName:         optionalString(d, "tls_server_name"),
+	}
+
+	minVersion := optionalString(d, "min_tls_version")
+	if minVersion != "" {
+		version, ok := TLSVersions[minVersion]
+		if !ok {
+			return nil, NewAttributeError(d, "min_tls_version", fmt.Sprintf("Unknown TLS version %q", minVersion), "")
+		}
+		config.MinVersion = version
+	}
+
+	caFile := optionalString(d, "ca_file")
+	caPEM := optionalString(d, "ca_pem")
+	providerCAs := providerMeta(m).CABundle
+	if caFile != "" || caPEM != "" || len(providerCAs) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if caFile != "" {
+			bundle, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, NewAttributeError(d, "ca_file", "Error reading ca_file", err.Error())
+			}
+			if !pool.AppendCertsFromPEM(bundle) {
+				return nil, NewAttributeError(d, "ca_file", "ca_file contains no PEM certificates", "")
+			}
+		}
+		if caPEM != "" && !pool.AppendCertsFromPEM([]byte(caPEM)) {
+			return nil, NewAttributeError(d, "ca_pem", "ca_pem contains no PEM certificates", "")
+		}
+		// checked when the provider was configured
+		pool.AppendCertsFromPEM(providerCAs)
+		config.RootCAs = pool
+	}
+
+	cert, err := clientCertificate(d)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return config, nil
+}
+
+// Returns a string attribute, or "" if the resource doesn't have it
+func optionalString(d *schema.ResourceData, key string) string {
+	v, _ := d.Get(key).(string)
+	return v
+}
+
+// Returns the TLS configuration of the requests the provider makes itself,
+// which trust the system roots and the CA bundle of the provider only
+func ProviderTLSConfig(caBundle []byte) *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(caBundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM(caBundle)
+		config.RootCAs = pool
+	}
+	return config
+}
+
+// Returns the client certificate of the resource, or nil if it has none
+func clientCertificate(d *schema.ResourceData) (*tls.Certificate, error) {
+	certFile := optionalString(d, "cert_file")
+	keyFile := optionalString(d, "key_file")
+	certPEM := optionalString(d, "cert_pem")
+	keyPEM := optionalString(d, "key_pem")
+
+	var certBlock, keyBlock []byte
+	switch {
+	case certFile != "" && certPEM != "":
+		return nil, NewAttributeError(d, "cert_pem", "Only one of cert_file or cert_pem can be set", "")
+	case certFile != "":
+		var err error
+		if certBlock, err = os.ReadFile(certFile); err != nil {
+			return nil, NewAttributeError(d, "cert_file", "Error reading cert_file", err.Error())
+		}
+	default:
+		certBlock = []byte(certPEM)
+	}
+	switch {
+	case keyFile != "" && keyPEM != "":
+		return nil, NewAttributeError(d, "key_pem", "Only one of key_file or key_pem can be set", "")
+	case keyFile != "":
+		var err error
+		if keyBlock, err = os.ReadFile(keyFile); err != nil {
+			return nil, NewAttributeError(d, "key_file", "Error reading key_file", err.Error())
+		}
+	default:
+		keyBlock = []byte(keyPEM)
+	}
+
+	if len(certBlock) == 0 && len(keyBlock) == 0 {
+		return nil, nil
+	}
+	if len(certBlock) == 0 || len(keyBlock) == 0 {
+		return nil, NewAttributeError(d, "cert_file", "A client certificate needs both a certificate and a key", "Set cert_file or cert_pem along with key_file or key_pem.")
+	}
+	cert, err := tls.X509KeyPair(certBlock, keyBlock)
+	if err != nil {
+		// the error doesn't include the key
+		return nil, NewAttributeError(d, "cert_file", "Error loading the client certificate", err.Error())
+	}
+	return &cert, nil
+}
+
+// Validates that a string attribute holds PEM encoded data
+func ValidatePEM(i interface{}, path cty.Path) diag.Diagnostics {
+	v, ok := i.(string)
+	if !ok || v == "" {
+		return nil
+	}
+	if block, _ := pem.Decode([]byte(v)); block == nil {
+		// don't echo the value, it may be a private key
+		return attributeDiagnostics(path, "Value is not PEM encoded", "Expected data such as -----BEGIN CERTIFICATE-----.")
+	}
+	return nil
+}
+
+// Reads a file of PEM encoded CA certificates, such as the ca_file of the
+// provider
+func ReadCABundle(path string) ([]byte, error) {
+	path, err := ExpandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", path)
+	}
+	return bundle, nil
+}
@@ -0,0 +1,167 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// Token endpoint of IBM Cloud IAM, used for api_key when token_url isn't set
+const DefaultIAMTokenURL = "https://iam.cloud.ibm.com/identity/token"
+
+// Grant type for exchanging an IBM Cloud API key for an access token
+const iamAPIKeyGrantType = "urn:ibm:params:oauth:grant-type:apikey"
+
+// Tokens are renewed this long before they expire, so a request isn't sent
+// with one that runs out on the way.
+const tokenExpiryLeeway = time.Minute
+
+// TokenSource obtains bearer tokens for the pattern manager from an identity
+// endpoint and caches them until they expire. One is shared by all the
+// resources of a provider, so tokens are only requested when needed.
+//
+// The grant follows from the credentials that are set: an IBM Cloud API key,
+// then a username and password, then the client credentials alone.
+type TokenSource struct {
+	URL          string
+	APIKey       string
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+	Scope        string
+	// The client of the token requests. It has the TLS, proxy and timeouts
+	// of the provider, never those of the resource that needs a token.
+	Client *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Returns the grant type used to obtain tokens
+func (ts *TokenSource) GrantType() string {
+	switch {
+	case ts.APIKey != "":
+		return iamAPIKeyGrantType
+	case ts.Username != "" && ts.Password != "":
+		return "password"
+	default:
+		return "client_credentials"
+	}
+}
+
+// Returns a valid token, requesting a new one if there's none
+func (ts *TokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != "" && time.Now().Add(tokenExpiryLeeway).Before(ts.expiry) {
+		return ts.token, nil
+	}
+	token, expiry, err := ts.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	ts.token = token
+	ts.expiry = expiry
+	return token, nil
+}
+
+// Drops token from the cache, if it's still there, so the next call to Token
+// requests a new one. Called when the pattern manager rejects it.
+func (ts *TokenSource) Invalidate(token string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token == token {
+		ts.token = ""
+	}
+}
+
+func (ts *TokenSource) requestToken(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{"grant_type": {ts.GrantType()}}
+	switch ts.GrantType() {
+	case iamAPIKeyGrantType:
+		form.Set("apikey", ts.APIKey)
+	case "password":
+		form.Set("username", ts.Username)
+		form.Set("password", ts.Password)
+	}
+	if ts.Scope != "" {
+		form.Set("scope", ts.Scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", ts.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if ts.ClientID != "" {
+		req.SetBasicAuth(ts.ClientID, ts.ClientSecret)
+	}
+
+	client := ts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("unable to connect to %s: %s", ts.URL, ts.redact(err.Error()))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error reading the response from %s: %s", ts.URL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("%s returned StatusCode:%v\nMessage:\n%s", ts.URL, resp.StatusCode, ts.redact(string(body)))
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		// IAM also reports when the token expires as a unix time
+		Expiration int64 `json:"expiration"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("%s returned no access_token", ts.URL)
+	}
+	var expiry time.Time
+	switch {
+	case result.Expiration > 0:
+		expiry = time.Unix(result.Expiration, 0)
+	case result.ExpiresIn > 0:
+		expiry = start.Add(time.Duration(result.ExpiresIn) * time.Second)
+	default:
+		// no expiry given, so the token is used until it's rejected
+		expiry = time.Now().Add(24 * time.Hour)
+	}
+	tflog.SubsystemDebug(ctx, SubsystemHTTP, "Obtained access token", map[string]interface{}{
+		"token_url":   ts.URL,
+		"grant_type":  ts.GrantType(),
+		"expiry":      expiry.Format(time.RFC3339),
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+	return result.AccessToken, expiry, nil
+}
+
+// Masks the credentials in messages from the identity endpoint
+func (ts *TokenSource) redact(msg string) string {
+	for _, v := range []string{ts.APIKey, ts.ClientSecret, ts.Password} {
+		if len(v) >= minRedactLength {
+			msg = strings.Replace(msg, v, redactedValue, -1)
+		}
+	}
+	return msg
+}
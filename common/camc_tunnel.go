@@ -0,0 +1,89 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+// The attributes of the bastion host that pattern manager requests are sent
+// through. A client is shared by resources with the same values.
+var bastionAttributes = []string{"bastion_host", "bastion_port", "bastion_user", "bastion_password", "bastion_private_key"}
+
+// bastionDialer opens the connections of an HTTP client through an ssh
+// connection to a bastion host. The ssh connection is made when the first one
+// is needed, shared by those that follow and made again if it's lost.
+type bastionDialer struct {
+	addr   string
+	config *ssh.ClientConfig
+	// the provider meta, for its ssh proxy
+	meta interface{}
+	// how long connecting to the bastion host can take
+	timeout time.Duration
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+func (b *bastionDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := b.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.DialContext(ctx, network, addr)
+}
+
+// Returns the ssh connection to the bastion host, connecting if there's none
+func (b *bastionDialer) connect(ctx context.Context) (*ssh.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client != nil {
+		return b.client, nil
+	}
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	client, err := dialSSH(ctx, b.meta, b.addr, b.config)
+	if err != nil {
+		return nil, err
+	}
+	b.client = client
+	// forget the connection once it's closed, the next dial makes a new one
+	go func() {
+		client.Wait()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.client == client {
+			b.client = nil
+		}
+	}()
+	return client, nil
+}
+
+// Returns the dialer of the pattern manager requests of a resource, through
+// its bastion host, or nil if it has none
+func patternManagerTunnel(d *schema.ResourceData, m interface{}) (*bastionDialer, error) {
+	if d.Get("bastion_host").(string) == "" {
+		return nil, nil
+	}
+	config, err := CreateBastionConfig(d, m)
+	if err != nil {
+		return nil, err
+	}
+	return &bastionDialer{
+		addr:    bastionAddress(d),
+		config:  config,
+		meta:    m,
+		timeout: ProviderClients(m).Timeouts.Connect,
+	}, nil
+}
@@ -0,0 +1,297 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package common
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+// configReader is implemented by both *schema.ResourceData and
+// *schema.ResourceDiff, so the same checks run at plan and at apply time.
+type configReader interface {
+	Get(key string) interface{}
+}
+
+// Reports whether the values of keys are known. Values computed from other
+// resources aren't known at plan time, checks that need them wait for apply.
+func isKnown(c configReader, keys ...string) bool {
+	diff, ok := c.(*schema.ResourceDiff)
+	if !ok {
+		return true
+	}
+	for _, key := range keys {
+		if !diff.NewValueKnown(key) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHTTPURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// Validates that a string attribute holds a JSON document. Any JSON value is
+// accepted, "null" sends no body.
+func ValidateJSON(i interface{}, path cty.Path) diag.Diagnostics {
+	v, ok := i.(string)
+	if !ok || v == "" {
+		return nil
+	}
+	if _, err := decodeJSON(v); err != nil {
+		// don't echo the value, it may have secrets
+		return attributeDiagnostics(path, "Value is not valid json", "A JSON document is expected.")
+	}
+	return nil
+}
+
+// DiffSuppressFunc of the JSON document attributes. Documents that differ only
+// in key order, whitespace or how numbers are written are the same document.
+func SuppressEquivalentJSON(k, old, new string, d *schema.ResourceData) bool {
+	if old == new {
+		return true
+	}
+	oldDoc, err := decodeJSON(old)
+	if err != nil {
+		return false
+	}
+	newDoc, err := decodeJSON(new)
+	if err != nil {
+		return false
+	}
+	return jsonEqual(oldDoc, newDoc)
+}
+
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		// compared exactly, 1, 1.0 and 1e0 are equal and large ids aren't rounded
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okA := new(big.Rat).SetString(a.String())
+		y, okB := new(big.Rat).SetString(b.String())
+		return okA && okB && x.Cmp(y) == 0
+	default:
+		return a == b
+	}
+}
+
+// Validates that a string attribute holds a base64 encoded private key
+func ValidatePrivateKey(i interface{}, path cty.Path) diag.Diagnostics {
+	v, ok := i.(string)
+	if !ok || v == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return attributeDiagnostics(path, "Error decoding private key", "The private key must be base64 encoded")
+	}
+	if _, err := ssh.ParsePrivateKey(key); err != nil {
+		return attributeDiagnostics(path, "Error parsing private key", err.Error())
+	}
+	return nil
+}
+
+// Validates that the keys of a map attribute are environment variable names
+func ValidateEnvironment(i interface{}, path cty.Path) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for k := range i.(map[string]interface{}) {
+		if !envNameRegexp.MatchString(k) {
+			diags = append(diags, attributeDiagnostics(path, fmt.Sprintf("Invalid variable name %q", k),
+				"Variable names must start with a letter or underscore and contain only letters, digits and underscores.")...)
+		}
+	}
+	return diags
+}
+
+// Validates that a string attribute holds a TCP port number
+func ValidatePort(i interface{}, path cty.Path) diag.Diagnostics {
+	v, ok := i.(string)
+	if !ok || v == "" {
+		return nil
+	}
+	if port, err := strconv.Atoi(v); err != nil || port < 1 || port > 65535 {
+		return attributeDiagnostics(path, fmt.Sprintf("Invalid port %q", v), "A port must be a number between 1 and 65535.")
+	}
+	return nil
+}
+
+// Validates that a string attribute holds an absolute http or https URL
+func ValidateEndpoint(i interface{}, path cty.Path) diag.Diagnostics {
+	v, ok := i.(string)
+	if !ok || v == "" {
+		return nil
+	}
+	if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return attributeDiagnostics(path, fmt.Sprintf("Invalid endpoint %q", v), "An http or https URL such as https://cam.example.com:30000 is expected.")
+	}
+	return nil
+}
+
+func attributeDiagnostics(path cty.Path, summary string, detail string) diag.Diagnostics {
+	return diag.Diagnostics{{
+		Severity:      diag.Error,
+		Summary:       summary,
+		Detail:        detail,
+		AttributePath: path,
+	}}
+}
+
+// CustomizeDiff of the script package resources. Checks the combinations of
+// attributes the schema can't express so a bad configuration fails the plan
+// rather than the apply.
+func ScriptPackageCustomizeDiff(ctx context.Context, diff *schema.ResourceDiff, m interface{}) error {
+	// nothing is run, so nothing needs to make sense
+	if !diff.Get("on_create").(bool) && !diff.Get("on_update").(bool) && !diff.Get("on_delete").(bool) {
+		return nil
+	}
+	checks := []func(configReader) error{
+		checkProgram,
+		checkSourceAndDest,
+		func(c configReader) error { return checkRemoteHost(c, m) },
+	}
+	for _, check := range checks {
+		if err := check(diff); err != nil {
+			return planError(err)
+		}
+	}
+	return nil
+}
+
+// The SDK reports a CustomizeDiff error without an attribute path, so the
+// attribute is named in the message instead.
+func planError(err error) error {
+	if e, ok := err.(*Error); ok && e.Attribute != "" {
+		return fmt.Errorf("%s: %s", e.Attribute, e.Error())
+	}
+	return err
+}
+
+func checkProgram(c configReader) error {
+	if !isKnown(c, "program", "program_sensitive") {
+		return nil
+	}
+	if len(c.Get("program").([]interface{})) < 1 && len(c.Get("program_sensitive").([]interface{})) < 1 {
+		return &Error{Summary: "program list must contain at least one element", Attribute: "program"}
+	}
+	return nil
+}
+
+// Checks the source of the program and where it's copied to
+func checkSourceAndDest(c configReader) error {
+	if !isKnown(c, "source", "destination") {
+		return nil
+	}
+	source := c.Get("source").(string)
+	destination := c.Get("destination").(string)
+	if source == "" {
+		if destination != "" {
+			return &Error{Summary: "destination was specified, but not source", Attribute: "destination"}
+		}
+		return nil
+	}
+	if destination == "" {
+		return &Error{Summary: "source was specified, but not destination", Attribute: "destination"}
+	}
+	if !isKnown(c, "remote_host") {
+		return nil
+	}
+	remoteHost := c.Get("remote_host").(string)
+	if !isHTTPURL(source) {
+		if remoteHost == "" {
+			return &Error{Summary: "Copying a file from one directory to another on the provider container is not supported", Attribute: "source"}
+		}
+		return nil
+	}
+	if strings.Contains(source, ";") {
+		return &Error{Summary: "source contains illegal chracter", Detail: ";", Attribute: "source"}
+	}
+	if strings.HasPrefix(source, "https://") && isKnown(c, "source_user", "source_password") &&
+		(c.Get("source_user").(string) == "" || c.Get("source_password").(string) == "") {
+		return &Error{Summary: "The source_user and source_password properties are required when source is an https URL", Attribute: "source_user"}
+	}
+	if remoteHost == "" {
+		if strings.HasPrefix(strings.TrimSpace(destination), "/") || strings.HasPrefix(strings.TrimSpace(destination), "~") {
+			return &Error{Summary: "The destination parameter must be a relative path when downloading locally", Attribute: "destination"}
+		}
+		if strings.Contains(destination, "..") {
+			return &Error{Summary: "The destination parameter cannot contain reference to a parent directory", Attribute: "destination"}
+		}
+	}
+	return nil
+}
+
+// Checks the credentials for the remote and bastion hosts. Hosts without a
+// password or key of their own use the ssh key of the provider.
+func checkRemoteHost(c configReader, m interface{}) error {
+	providerKey := providerMeta(m).SSHPrivateKey != ""
+	if !isKnown(c, "remote_host") {
+		return nil
+	}
+	if c.Get("remote_host").(string) == "" {
+		if isKnown(c, "become") && c.Get("become").(bool) {
+			return &Error{Summary: "become is only supported when specifying remote_host", Attribute: "become"}
+		}
+		return nil
+	}
+	if isKnown(c, "remote_user") && c.Get("remote_user").(string) == "" {
+		return &Error{Summary: "remote_user is required when specifying remote_host", Attribute: "remote_user"}
+	}
+	if isKnown(c, "remote_password", "remote_key") && c.Get("remote_password").(string) == "" && c.Get("remote_key").(string) == "" && !providerKey {
+		return &Error{Summary: "One of remote_password or remote_key is required when specifying remote_host", Attribute: "remote_password"}
+	}
+	return checkBastion(c, m)
+}
+
+// Checks the credentials for the bastion host, if one is set
+func checkBastion(c configReader, m interface{}) error {
+	if !isKnown(c, "bastion_host") || c.Get("bastion_host").(string) == "" {
+		return nil
+	}
+	if isKnown(c, "bastion_user") && c.Get("bastion_user").(string) == "" {
+		return &Error{Summary: "bastion_user is required when specifying bastion_host", Attribute: "bastion_user"}
+	}
+	if isKnown(c, "bastion_password", "bastion_private_key") && c.Get("bastion_password").(string) == "" && c.Get("bastion_private_key").(string) == "" && providerMeta(m).SSHPrivateKey == "" {
+		return &Error{Summary: "Bastion host password and private key is empty", Detail: "Provide value for bastion_password or bastion_private_key", Attribute: "bastion_password"}
+	}
+	return nil
+}
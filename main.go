@@ -5,11 +5,35 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+
 	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
 )
 
+// Set when building a release with
+// -ldflags "-X main.version=<version> -X main.commit=<commit>"
+var (
+	version = "dev"
+	commit  = "none"
+)
+
 func main() {
+	var debug, printVersion bool
+	flag.BoolVar(&debug, "debug", false, "start the provider in debug mode for use with a debugger such as delve")
+	flag.BoolVar(&printVersion, "version", false, "print the version of the provider and exit")
+	flag.Parse()
+
+	if printVersion {
+		fmt.Printf("terraform-provider-camc %s (commit %s)\n", version, commit)
+		return
+	}
+
+	// In debug mode the provider prints the TF_REATTACH_PROVIDERS value that
+	// points Terraform at it, and keeps running until it is interrupted.
 	plugin.Serve(&plugin.ServeOpts{
 		ProviderFunc: Provider,
+		Debug:        debug,
+		ProviderAddr: "registry.terraform.io/IBM-CAMHub-Open/camc",
 	})
 }
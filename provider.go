@@ -5,13 +5,202 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/IBM-CAMHub-Open/terraform-provider-camc/common"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func Provider() *schema.Provider {
-	return &schema.Provider{
+	p := &schema.Provider{
+		// Attributes that aren't set are read from the CAMC_ environment
+		// variables, then from the profile in config_file.
+		Schema: map[string]*schema.Schema{
+			"profile": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_PROFILE", nil),
+			},
+
+			"config_file": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_CONFIG_FILE", nil),
+			},
+
+			// the URL relative camc_endpoint values are resolved against
+			"endpoint": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("CAMC_ENDPOINT", nil),
+				ValidateDiagFunc: common.ValidateEndpoint,
+			},
+
+			// sent by resources that don't set access_token
+			"access_token": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_ACCESS_TOKEN", nil),
+			},
+
+			// trusted by every pattern manager resource
+			"ca_file": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_CA_FILE", nil),
+			},
+
+			// base64 encoded, like remote_key, for the remote and bastion hosts
+			// without a password or key of their own
+			"ssh_private_key": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        true,
+				DefaultFunc:      schema.EnvDefaultFunc("CAMC_SSH_PRIVATE_KEY", nil),
+				ValidateDiagFunc: common.ValidatePrivateKey,
+			},
+
+			// Credentials to obtain access tokens for resources that don't set
+			// access_token. api_key is exchanged with IBM Cloud IAM by default.
+			"token_url": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_TOKEN_URL", nil),
+			},
+
+			"api_key": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_API_KEY", nil),
+				Sensitive:   true,
+			},
+
+			"client_id": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_CLIENT_ID", nil),
+			},
+
+			"client_secret": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_CLIENT_SECRET", nil),
+				Sensitive:   true,
+			},
+
+			"username": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_USERNAME", nil),
+			},
+
+			"password": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_PASSWORD", nil),
+				Sensitive:   true,
+			},
+
+			"scope": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_SCOPE", nil),
+			},
+
+			// limits of the pattern manager requests, such as 30s or 5m. A
+			// request has no limit other than the resource's timeouts by
+			// default, the pattern manager can take long to answer.
+			"connect_timeout": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "15s",
+				ValidateDiagFunc: common.ValidateDuration,
+			},
+
+			"tls_handshake_timeout": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "10s",
+				ValidateDiagFunc: common.ValidateDuration,
+			},
+
+			"response_header_timeout": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "0",
+				ValidateDiagFunc: common.ValidateDuration,
+			},
+
+			"request_timeout": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "0",
+				ValidateDiagFunc: common.ValidateDuration,
+			},
+
+			// added to every pattern manager request, resources can replace them
+			"headers": &schema.Schema{
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				ValidateDiagFunc: common.ValidateHeaders,
+			},
+
+			"sensitive_headers": &schema.Schema{
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Sensitive:        true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				ValidateDiagFunc: common.ValidateHeaders,
+			},
+
+			// the proxy of the pattern manager requests, HTTP_PROXY and
+			// HTTPS_PROXY are used if it isn't set
+			"http_proxy": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("CAMC_HTTP_PROXY", nil),
+				ValidateDiagFunc: common.ValidateProxyURL(common.HTTPProxySchemes...),
+			},
+
+			// hosts connected to directly, such as "10.0.0.0/8,.internal.example.com"
+			"no_proxy": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_NO_PROXY", nil),
+			},
+
+			// the SOCKS5 or HTTP CONNECT proxy of the ssh connections to
+			// remote_host or bastion_host
+			"ssh_proxy": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("CAMC_SSH_PROXY", nil),
+				ValidateDiagFunc: common.ValidateProxyURL(common.SSHProxySchemes...),
+			},
+
+			// credentials of the proxies whose URL doesn't have them
+			"proxy_username": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_PROXY_USERNAME", nil),
+			},
+
+			"proxy_password": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CAMC_PROXY_PASSWORD", nil),
+				Sensitive:   true,
+			},
+		},
+
 		ResourcesMap: map[string]*schema.Resource{
 			"camc_bootstrap":               resourceCamcBootstrap(),
 			"camc_scriptpackage":           resourceCamcScriptPackage(),
@@ -20,19 +209,210 @@ func Provider() *schema.Provider {
 			"camc_vaultitem":               resourceCamcVaultitem(),
 		},
 	}
+	p.ConfigureContextFunc = func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+		settings, diags := readProviderSettings(d)
+		if diags.HasError() {
+			return nil, diags
+		}
+		// values from the profile weren't validated with the schema
+		if diags = append(diags, settings.validate(p.Schema)...); diags.HasError() {
+			return nil, diags
+		}
+		tokens, tokenDiags := providerTokenSource(settings)
+		diags = append(diags, tokenDiags...)
+		sensitiveHeaders := common.StringMap(d.Get("sensitive_headers"))
+		proxy := providerProxy(settings)
+		meta := &common.ProviderMeta{
+			UserAgent:        p.UserAgent("terraform-provider-camc", version),
+			Tokens:           tokens,
+			Headers:          common.StringMap(d.Get("headers")),
+			SensitiveHeaders: sensitiveHeaders,
+			Clients:          common.NewClientPool(providerTimeouts(d), proxy),
+			Proxy:            proxy,
+			Endpoint:         settings.Get("endpoint"),
+			AccessToken:      settings.Get("access_token"),
+			SSHPrivateKey:    settings.Get("ssh_private_key"),
+		}
+		meta.SensitiveValues = append(meta.SensitiveValues, meta.AccessToken, meta.SSHPrivateKey)
+		for _, v := range sensitiveHeaders {
+			meta.SensitiveValues = append(meta.SensitiveValues, v)
+		}
+		if proxy != nil {
+			meta.SensitiveValues = append(meta.SensitiveValues, proxy.Secrets()...)
+		}
+		if caFile := settings.Get("ca_file"); caFile != "" {
+			bundle, err := common.ReadCABundle(caFile)
+			if err != nil {
+				diags = append(diags, diag.Diagnostic{
+					Severity:      diag.Error,
+					Summary:       "Error reading ca_file",
+					Detail:        err.Error(),
+					AttributePath: cty.GetAttrPath("ca_file"),
+				})
+			}
+			meta.CABundle = bundle
+		}
+		if diags.HasError() {
+			return nil, diags
+		}
+		if tokens != nil {
+			// whichever resource needs the token first, it's obtained with
+			// the provider's settings only
+			tokens.Client = meta.Clients.Client("token_url", common.ProviderTLSConfig(meta.CABundle), nil)
+		}
+		return meta, diags
+	}
+	// let common mask the values of sensitive attributes in traces and errors
+	for _, r := range p.ResourcesMap {
+		common.RedactResource(r)
+	}
+	return p
+}
+
+// Returns the source of access tokens for the credentials configured for
+// the provider, or nil if there are none and every resource sets access_token.
+func providerTokenSource(s *providerSettings) (*common.TokenSource, diag.Diagnostics) {
+	tokens := &common.TokenSource{
+		URL:          s.Get("token_url"),
+		APIKey:       s.Get("api_key"),
+		ClientID:     s.Get("client_id"),
+		ClientSecret: s.Get("client_secret"),
+		Username:     s.Get("username"),
+		Password:     s.Get("password"),
+		Scope:        s.Get("scope"),
+	}
+	if tokens.APIKey == "" && tokens.ClientID == "" && tokens.Username == "" {
+		if tokens.URL != "" {
+			return nil, diag.Diagnostics{{
+				Severity:      diag.Error,
+				Summary:       "token_url requires credentials",
+				Detail:        "Set api_key, client_id and client_secret, or username and password.",
+				AttributePath: cty.GetAttrPath("token_url"),
+			}}
+		}
+		return nil, nil
+	}
+	if tokens.URL == "" {
+		if tokens.APIKey == "" {
+			return nil, diag.Diagnostics{{
+				Severity:      diag.Error,
+				Summary:       "token_url is required",
+				Detail:        "The identity endpoint to obtain access tokens from is only known for api_key.",
+				AttributePath: cty.GetAttrPath("token_url"),
+			}}
+		}
+		tokens.URL = common.DefaultIAMTokenURL
+	}
+	return tokens, nil
+}
+
+// Returns the timeouts of the pattern manager requests. They're validated, and
+// 0 turns a limit off.
+func providerTimeouts(d *schema.ResourceData) common.HTTPTimeouts {
+	duration := func(key string) time.Duration {
+		v, _ := time.ParseDuration(d.Get(key).(string))
+		return v
+	}
+	return common.HTTPTimeouts{
+		Connect:        duration("connect_timeout"),
+		TLSHandshake:   duration("tls_handshake_timeout"),
+		ResponseHeader: duration("response_header_timeout"),
+		Request:        duration("request_timeout"),
+	}
+}
+
+// Returns the proxies of the provider, or nil if none is configured and the
+// environment's are used
+func providerProxy(s *providerSettings) *common.ProxyConfig {
+	proxy := &common.ProxyConfig{
+		HTTPProxy: s.Get("http_proxy"),
+		NoProxy:   s.Get("no_proxy"),
+		SSHProxy:  s.Get("ssh_proxy"),
+		Username:  s.Get("proxy_username"),
+		Password:  s.Get("proxy_password"),
+	}
+	if *proxy == (common.ProxyConfig{}) {
+		return nil
+	}
+	return proxy
+}
+
+// The attributes of the provider that a profile can set
+var profileKeys = []string{
+	"endpoint", "access_token", "ca_file", "ssh_private_key",
+	"token_url", "api_key", "client_id", "client_secret", "username", "password", "scope",
+	"http_proxy", "no_proxy", "ssh_proxy", "proxy_username", "proxy_password",
 }
 
-func jsonStateFunc(value interface{}) string {
-	// Parse and re-stringify the JSON to make sure it's always kept
-	// in a normalized form.
-	in, ok := value.(string)
-	if !ok {
-		return "null"
+// providerSettings reads the attributes of the provider. Those not set in its
+// configuration or environment variables come from its profile.
+type providerSettings struct {
+	d       *schema.ResourceData
+	profile map[string]string
+}
+
+func (s *providerSettings) Get(key string) string {
+	if v, _ := s.d.Get(key).(string); v != "" {
+		return v
 	}
-	var tmp map[string]interface{}
+	return s.profile[key]
+}
 
-	json.Unmarshal([]byte(in), &tmp)
+// Runs the validators of the attributes on the values that come from the
+// profile
+func (s *providerSettings) validate(attributes map[string]*schema.Schema) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for _, key := range profileKeys {
+		validate := attributes[key].ValidateDiagFunc
+		if v, _ := s.d.Get(key).(string); v != "" || validate == nil {
+			continue
+		}
+		if v, ok := s.profile[key]; ok {
+			diags = append(diags, validate(v, cty.GetAttrPath(key))...)
+		}
+	}
+	return diags
+}
 
-	jsonValue, _ := json.Marshal(&tmp)
-	return string(jsonValue)
+// Reads the profile of the provider. The default profile of the default file
+// is optional, a profile or file that's asked for must exist.
+func readProviderSettings(d *schema.ResourceData) (*providerSettings, diag.Diagnostics) {
+	path := d.Get("config_file").(string)
+	name := d.Get("profile").(string)
+	required := path != "" || name != ""
+	if path == "" {
+		path = common.DefaultConfigFile
+	}
+	if name == "" {
+		name = common.DefaultProfile
+	}
+	profile, exists, err := common.ReadProfile(path, name)
+	if err != nil {
+		return nil, diag.Diagnostics{{
+			Severity:      diag.Error,
+			Summary:       "Error reading config_file",
+			Detail:        err.Error(),
+			AttributePath: cty.GetAttrPath("config_file"),
+		}}
+	}
+	if !exists && required {
+		return nil, diag.Diagnostics{{
+			Severity:      diag.Error,
+			Summary:       fmt.Sprintf("Profile %q not found", name),
+			Detail:        fmt.Sprintf("%s doesn't exist or has no [%s] section.", path, name),
+			AttributePath: cty.GetAttrPath("profile"),
+		}}
+	}
+	var diags diag.Diagnostics
+	for key := range profile {
+		if !slices.Contains(profileKeys, key) {
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       fmt.Sprintf("Unknown setting %q in profile %q", key, name),
+				Detail:        fmt.Sprintf("A profile can set %s.", strings.Join(profileKeys, ", ")),
+				AttributePath: cty.GetAttrPath("profile"),
+			})
+		}
+	}
+	return &providerSettings{d: d, profile: profile}, diags
 }
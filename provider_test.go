@@ -0,0 +1,1126 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM-CAMHub-Open/terraform-provider-camc/common"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// The cases below run Terraform against the provider, served in process, and
+// the stand-ins in testserver_test.go. They're skipped unless TF_ACC is set.
+// Terraform is looked for on the PATH, or set TF_ACC_TERRAFORM_PATH.
+
+// Keeps the CAMC_ variables and ~/.camc/config of whoever runs the tests out
+// of the providers they configure
+func TestMain(m *testing.M) {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "CAMC_") {
+			os.Unsetenv(kv[:strings.Index(kv, "=")])
+		}
+	}
+	home, err := os.MkdirTemp("", "camc-home")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+func TestProvider(t *testing.T) {
+	if err := Provider().InternalValidate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProviderTokens(t *testing.T) {
+	identity := newIdentityStub(t)
+	stub := newPatternManagerStub(t)
+	provider := providerConfig(map[string]interface{}{
+		"token_url":     identity.URL,
+		"client_id":     "camc-client",
+		"client_secret": "client-secret-1234",
+	})
+	// software deployments send nothing when destroyed, with a token that
+	// would no longer be accepted
+	config := map[string]interface{}{
+		"camc_endpoint": stub.URL,
+		"data":          `{"name":"first"}`,
+	}
+	shared := provider +
+		namedResourceConfig("camc_softwaredeploy", "first", config) +
+		namedResourceConfig("camc_softwaredeploy", "second", config)
+
+	runSteps(t,
+		// the token is obtained once and shared by the resources
+		resource.TestStep{
+			PreConfig: func() { stub.RequireToken("issued-token-1") },
+			Config:    shared,
+			Check: func(*terraform.State) error {
+				forms := identity.Forms()
+				if len(forms) != 1 || forms[0].Get("grant_type") != "client_credentials" {
+					return fmt.Errorf("identity endpoint was sent %v, expected one client_credentials grant", forms)
+				}
+				if client := identity.Client(); client != "camc-client" {
+					return fmt.Errorf("identity endpoint was sent the client %q", client)
+				}
+				if requests := stub.Requests(); len(requests) != 2 || requests[1].Authorization != "Bearer issued-token-1" {
+					return fmt.Errorf("pattern manager was sent %+v", requests)
+				}
+				return nil
+			},
+		},
+		// a rejected token is replaced and the request retried. Terraform
+		// starts the provider again, which obtains issued-token-2.
+		resource.TestStep{
+			PreConfig: func() { stub.RequireToken("issued-token-3") },
+			Config:    shared + namedResourceConfig("camc_softwaredeploy", "third", config),
+			Check: func(*terraform.State) error {
+				if forms := identity.Forms(); len(forms) != 3 {
+					return fmt.Errorf("identity endpoint was sent %v, expected a grant then a second one", forms)
+				}
+				requests := stub.Requests()
+				if len(requests) != 2 || requests[0].Authorization != "Bearer issued-token-2" || requests[1].Authorization != "Bearer issued-token-3" {
+					return fmt.Errorf("pattern manager was sent %+v, expected a retry with the new token", requests)
+				}
+				return nil
+			},
+		},
+	)
+
+	// a static access_token is used as is
+	stub.RequireToken("static-token-1234")
+	runSteps(t, resource.TestStep{
+		Config: provider + resourceConfig("camc_softwaredeploy", withSettings(config, map[string]interface{}{
+			"access_token": "static-token-1234",
+		})),
+		Check: func(*terraform.State) error {
+			if forms := identity.Forms(); len(forms) != 3 {
+				return fmt.Errorf("identity endpoint was sent %v, expected no more grants", forms)
+			}
+			return nil
+		},
+	})
+
+	// without either the request can't be made
+	runSteps(t, resource.TestStep{
+		Config:      resourceConfig("camc_softwaredeploy", config),
+		ExpectError: errorMatching("No access_token supplied"),
+	})
+}
+
+func TestProviderTokensAPIKey(t *testing.T) {
+	identity := newIdentityStub(t)
+	stub := newPatternManagerStub(t)
+	runSteps(t, resource.TestStep{
+		Config: providerConfig(map[string]interface{}{
+			"token_url": identity.URL,
+			"api_key":   "api-key-1234",
+		}) + resourceConfig("camc_bootstrap", map[string]interface{}{
+			"camc_endpoint": stub.URL,
+		}),
+		Check: func(*terraform.State) error {
+			forms := identity.Forms()
+			if len(forms) != 1 || forms[0].Get("grant_type") != "urn:ibm:params:oauth:grant-type:apikey" || forms[0].Get("apikey") != "api-key-1234" {
+				return fmt.Errorf("identity endpoint was sent %v, expected an api key grant", forms)
+			}
+			return nil
+		},
+	})
+}
+
+func TestProviderTokensTLS(t *testing.T) {
+	identity := newIdentityStubTLS(t)
+	stub := newPatternManagerStubTLS(t, nil)
+	settings := map[string]interface{}{
+		"token_url":     identity.URL,
+		"client_id":     "camc-client",
+		"client_secret": "client-secret-1234",
+	}
+
+	// the TLS settings of a resource don't apply to the token request
+	for _, c := range []struct {
+		attribute string
+		value     interface{}
+	}{
+		{"skip_ssl_verify", true},
+		{"ca_pem", stub.CertificatePEM()},
+	} {
+		t.Run(c.attribute, func(t *testing.T) {
+			runSteps(t, resource.TestStep{
+				Config: providerConfig(settings) + resourceConfig("camc_bootstrap", map[string]interface{}{
+					"camc_endpoint": stub.URL,
+					c.attribute:     c.value,
+				}),
+				ExpectError: errorMatching("certificate signed by unknown authority"),
+			})
+			if forms := identity.Forms(); len(forms) != 0 {
+				t.Fatalf("identity endpoint was sent %v", forms)
+			}
+		})
+	}
+
+	// the provider's CA bundle does
+	caFile := writeCAFile(t, identity.CertificatePEM())
+	stub.RequireToken("issued-token-1")
+	runSteps(t, resource.TestStep{
+		Config: providerConfig(withSettings(settings, map[string]interface{}{"ca_file": caFile})) +
+			resourceConfig("camc_softwaredeploy", map[string]interface{}{
+				"camc_endpoint": stub.URL,
+			}),
+		Check: func(*terraform.State) error {
+			if forms := identity.Forms(); len(forms) != 1 {
+				return fmt.Errorf("identity endpoint was sent %v, expected one grant", forms)
+			}
+			return nil
+		},
+	})
+}
+
+func TestProviderClients(t *testing.T) {
+	stub := newUnstartedPatternManagerStub(t)
+	stub.EnableHTTP2 = true
+	stub.StartTLS()
+	config := map[string]interface{}{
+		"camc_endpoint": stub.URL,
+		"access_token":  "access-token-1234",
+		"ca_pem":        stub.CertificatePEM(),
+	}
+
+	// one after the other, so that a connection is there to be shared.
+	// Resources with the same TLS settings share it, other settings need a
+	// client of their own.
+	runSteps(t, resource.TestStep{
+		Config: namedResourceConfig("camc_bootstrap", "first", config) +
+			namedResourceConfig("camc_softwaredeploy", "second", withSettings(config, map[string]interface{}{
+				"depends_on": hcl("[camc_bootstrap.first]"),
+			})) +
+			namedResourceConfig("camc_vaultitem", "third", withSettings(config, map[string]interface{}{
+				"depends_on": hcl("[camc_softwaredeploy.second]"),
+			})) +
+			namedResourceConfig("camc_bootstrap", "fourth", withSettings(config, map[string]interface{}{
+				"tls_server_name": "example.com",
+				"depends_on":      hcl("[camc_vaultitem.third]"),
+			})),
+		Check: func(*terraform.State) error {
+			requests := stub.Requests()
+			if len(requests) != 4 {
+				return fmt.Errorf("sent %+v", requests)
+			}
+			for _, request := range requests {
+				if request.Proto != "HTTP/2.0" {
+					return fmt.Errorf("request was sent with %s", request.Proto)
+				}
+			}
+			if n := stub.Connections(); n != 2 {
+				return fmt.Errorf("requests were sent over %d connections", n)
+			}
+			return nil
+		},
+	})
+}
+
+func TestProviderTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/body" {
+			// headers now, the body never
+			w.WriteHeader(200)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	for _, c := range []struct {
+		name     string
+		provider map[string]interface{}
+		resource map[string]interface{}
+		path     string
+		err      string
+	}{
+		{"response header", map[string]interface{}{"response_header_timeout": "100ms"}, nil, "/headers", "timeout awaiting response headers"},
+		{"request", map[string]interface{}{"request_timeout": "100ms"}, nil, "/body", "Client.Timeout"},
+		// by default the resource's timeout is the limit
+		{"default", nil, map[string]interface{}{"timeouts": block{"create": "1s"}}, "/headers", "context deadline exceeded"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Now()
+			runSteps(t, resource.TestStep{
+				Config: providerConfig(c.provider) + resourceConfig("camc_bootstrap", withSettings(map[string]interface{}{
+					"camc_endpoint": slow.URL + c.path,
+					"access_token":  "access-token-1234",
+				}, c.resource)),
+				ExpectError: errorMatching(c.err),
+			})
+			// Terraform itself takes a few seconds
+			if elapsed := time.Since(start); elapsed > 30*time.Second {
+				t.Errorf("the request took %s", elapsed)
+			}
+		})
+	}
+
+	runSteps(t, resource.TestStep{
+		Config: providerConfig(map[string]interface{}{"connect_timeout": "soon"}) + resourceConfig("camc_bootstrap", map[string]interface{}{
+			"camc_endpoint": slow.URL,
+			"access_token":  "access-token-1234",
+		}),
+		PlanOnly:    true,
+		ExpectError: errorMatching(`Invalid duration "soon"`),
+	})
+}
+
+func TestProviderProxy(t *testing.T) {
+	stub := newPatternManagerStub(t)
+	// a name only the proxy resolves, loopback addresses are never proxied
+	endpoint := "pattern-manager.example:8080"
+	proxy := newHTTPProxyStub(t, "proxy-user", "proxy-password-1234", map[string]string{endpoint: stub.Listener.Addr().String()})
+	config := resourceConfig("camc_softwaredeploy", map[string]interface{}{
+		"camc_endpoint": "http://" + endpoint + "/bootstrap",
+		"access_token":  "access-token-1234",
+	})
+
+	runSteps(t, resource.TestStep{
+		Config: providerConfig(map[string]interface{}{
+			"http_proxy":     proxy.URL,
+			"proxy_username": "proxy-user",
+			"proxy_password": "proxy-password-1234",
+		}) + config,
+		Check: func(*terraform.State) error {
+			if targets := proxy.Targets(); len(targets) != 1 || targets[0] != endpoint {
+				return fmt.Errorf("the proxy was asked for %q", targets)
+			}
+			requests := stub.Requests()
+			if len(requests) != 1 || requests[0].Path != "/bootstrap" || requests[0].Header.Get("Proxy-Authorization") != "" {
+				return fmt.Errorf("the pattern manager was sent %+v", requests)
+			}
+			return nil
+		},
+	})
+
+	// or from the environment
+	t.Setenv("CAMC_HTTP_PROXY", proxy.URL)
+	t.Setenv("CAMC_PROXY_USERNAME", "proxy-user")
+	t.Setenv("CAMC_PROXY_PASSWORD", "proxy-password-1234")
+	runSteps(t, resource.TestStep{
+		Config: config,
+		Check: func(*terraform.State) error {
+			if targets := proxy.Targets(); len(targets) != 1 || targets[0] != endpoint {
+				return fmt.Errorf("the proxy was asked for %q", targets)
+			}
+			return nil
+		},
+	})
+	// unless the host is in no_proxy
+	t.Setenv("CAMC_NO_PROXY", "pattern-manager.example")
+	runSteps(t, resource.TestStep{
+		Config:      config,
+		ExpectError: errorMatching("Unable to connect to endpoint"),
+	})
+	if targets := proxy.Targets(); len(targets) != 0 {
+		t.Fatalf("the proxy was asked for %q", targets)
+	}
+	for _, k := range []string{"CAMC_HTTP_PROXY", "CAMC_NO_PROXY", "CAMC_PROXY_USERNAME", "CAMC_PROXY_PASSWORD"} {
+		t.Setenv(k, "")
+	}
+
+	// credentials in the URL are used as they are
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("proxy-user", "wrong-password-1234")
+	tp := runSteps(t, resource.TestStep{
+		Config: providerConfig(map[string]interface{}{
+			"http_proxy":     proxyURL.String(),
+			"proxy_username": "proxy-user",
+			"proxy_password": "proxy-password-1234",
+		}) + config,
+		ExpectError: errorMatching("407 Proxy Authentication Required"),
+	})
+	tp.expectRedacted(t, "wrong-password-1234")
+
+	for _, c := range []struct {
+		key   string
+		value string
+		err   string
+	}{
+		{"http_proxy", "ftp://proxy.example.com", `Unsupported proxy scheme "ftp"`},
+		{"http_proxy", "proxy.example.com:3128", "Invalid proxy URL"},
+		{"ssh_proxy", "socks4://proxy.example.com", `Unsupported proxy scheme "socks4"`},
+	} {
+		runSteps(t, resource.TestStep{
+			Config:      providerConfig(map[string]interface{}{c.key: c.value}) + config,
+			PlanOnly:    true,
+			ExpectError: errorMatching(c.err),
+		})
+	}
+}
+
+// Secrets are masked in what's reported about the resources of the provider
+// they're configured for, and a sensitive attribute only where it's sensitive
+func TestProviderRedaction(t *testing.T) {
+	stub := newPatternManagerStub(t)
+	stub.Respond(500, `save of "vaulted-secret-1234" rejected for provider-token-1234`)
+	config := func(typeName string, settings map[string]interface{}) string {
+		return resourceConfig(typeName, withSettings(map[string]interface{}{
+			"camc_endpoint": stub.URL,
+			"data":          `"vaulted-secret-1234"`,
+		}, settings))
+	}
+
+	tp := runSteps(t, resource.TestStep{
+		Config:      providerConfig(map[string]interface{}{"access_token": "provider-token-1234"}) + config("camc_vaultitem", nil),
+		ExpectError: errorMatching("POST / returned 500 Internal Server Error"),
+	})
+	tp.expectRedacted(t, "vaulted-secret-1234")
+	tp.expectRedacted(t, "provider-token-1234")
+
+	// the data of camc_bootstrap isn't sensitive, and this provider has
+	// another access token
+	tp = runSteps(t, resource.TestStep{
+		Config:      config("camc_bootstrap", map[string]interface{}{"access_token": "resource-token-5678"}),
+		ExpectError: errorMatching("POST / returned 500 Internal Server Error"),
+	})
+	tp.expectError(t, `save of "vaulted-secret-1234" rejected for provider-token-1234`)
+}
+
+func TestProviderSettings(t *testing.T) {
+	stub := newPatternManagerStub(t)
+	stubTLS := newPatternManagerStubTLS(t, nil)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	caFile := filepath.Join(home, "ca.pem")
+	if err := os.WriteFile(caFile, []byte(stubTLS.CertificatePEM()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(home, ".camc"), 0700)
+	configFile := filepath.Join(home, ".camc", "config")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`# profiles of the tests
+[default]
+endpoint = ` + stub.URL + `/default
+
+[ci]
+endpoint     = "` + stubTLS.URL + `/cam/api/v1/"
+access_token = profile-token-5678
+ca_file      = ` + caFile + `
+`)
+	// relative to the endpoint of the provider, as a directory
+	config := resourceConfig("camc_softwaredeploy", map[string]interface{}{"camc_endpoint": "bootstrap"})
+	expectRequest := func(stub *patternManagerStub, path string, authorization string) resource.TestCheckFunc {
+		return func(*terraform.State) error {
+			requests := stub.Requests()
+			if len(requests) != 1 || requests[0].Path != path || requests[0].Authorization != authorization {
+				return fmt.Errorf("sent %+v, expected %s with %q", requests, path, authorization)
+			}
+			return nil
+		}
+	}
+
+	// environment variables win over the profile
+	t.Setenv("CAMC_ACCESS_TOKEN", "env-token-1234")
+	runSteps(t, resource.TestStep{
+		Config: config,
+		Check:  expectRequest(stub, "/default/bootstrap", "Bearer env-token-1234"),
+	})
+	t.Setenv("CAMC_ENDPOINT", stub.URL+"/env")
+	runSteps(t, resource.TestStep{
+		Config: config,
+		Check:  expectRequest(stub, "/env/bootstrap", "Bearer env-token-1234"),
+	})
+	t.Setenv("CAMC_ENDPOINT", "")
+	t.Setenv("CAMC_ACCESS_TOKEN", "")
+
+	// the profile's ca_file is trusted
+	t.Setenv("CAMC_PROFILE", "ci")
+	runSteps(t, resource.TestStep{
+		Config: config,
+		Check:  expectRequest(stubTLS, "/cam/api/v1/bootstrap", "Bearer profile-token-5678"),
+	})
+
+	// and the configuration wins over both
+	provider := providerConfig(map[string]interface{}{"access_token": "config-token-9012"})
+	runSteps(t, resource.TestStep{
+		Config: provider + config,
+		Check:  expectRequest(stubTLS, "/cam/api/v1/bootstrap", "Bearer config-token-9012"),
+	})
+	runSteps(t, resource.TestStep{
+		Config: provider + resourceConfig("camc_softwaredeploy", map[string]interface{}{
+			"camc_endpoint": "/other/bootstrap",
+			"access_token":  "access-token-1234",
+		}),
+		Check: expectRequest(stubTLS, "/other/bootstrap", "Bearer access-token-1234"),
+	})
+	t.Setenv("CAMC_PROFILE", "")
+
+	for _, c := range []struct {
+		name     string
+		content  string
+		settings map[string]interface{}
+		err      string
+	}{
+		{"missing profile", "[default]\n", map[string]interface{}{"profile": "staging"}, `Profile "staging" not found`},
+		{"missing file", "", map[string]interface{}{"config_file": filepath.Join(home, "missing")}, `Profile "default" not found`},
+		{"unknown setting", "[default]\nacess_token = x\n", nil, `Unknown setting "acess_token" in profile "default"`},
+		{"malformed", "[default]\nendpoint\n", nil, "expected a [profile] or a key = value line"},
+		{"invalid endpoint", "[default]\nendpoint = cam.example.com\n", nil, `Invalid endpoint "cam.example.com"`},
+		{"ca_file without PEM", "[default]\nca_file = " + configFile + "\n", nil, "contains no PEM certificates"},
+		{"invalid http_proxy", "[default]\nhttp_proxy = ftp://proxy.example.com\n", nil, `Unsupported proxy scheme "ftp"`},
+		{"invalid ssh_proxy", "[default]\nssh_proxy = proxy.example.com:1080\n", nil, "Invalid proxy URL"},
+		{"invalid ssh_private_key", "[default]\nssh_private_key = not-a-key\n", nil, "Error decoding private key"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			writeConfig(c.content)
+			runSteps(t, resource.TestStep{
+				Config:      providerConfig(c.settings) + config,
+				PlanOnly:    true,
+				ExpectError: errorMatching(c.err),
+			})
+		})
+	}
+
+	// without a file, the default profile is optional
+	os.Remove(configFile)
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig("camc_softwaredeploy", map[string]interface{}{
+			"camc_endpoint": stub.URL,
+			"access_token":  "access-token-1234",
+		}),
+		PlanOnly:           true,
+		ExpectNonEmptyPlan: true,
+	})
+}
+
+// testProvider serves the provider to Terraform in process. It records the
+// diagnostics of every response, as Terraform only passes the errors on to
+// the tests, and only as text.
+type testProvider struct {
+	mu      sync.Mutex
+	diags   []*tfprotov5.Diagnostic
+	checked int
+}
+
+// Returns the provider factories of a test case. camc isn't served by the
+// provider, so it's added for its tests.
+func (tp *testProvider) factories() map[string]func() (tfprotov5.ProviderServer, error) {
+	return map[string]func() (tfprotov5.ProviderServer, error){
+		"camc": func() (tfprotov5.ProviderServer, error) {
+			p := Provider()
+			p.ResourcesMap["camc"] = common.RedactResource(resourceCAMC())
+			return &recordingServer{ProviderServer: p.GRPCProvider(), tp: tp}, nil
+		},
+	}
+}
+
+func (tp *testProvider) record(diags []*tfprotov5.Diagnostic) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.diags = append(tp.diags, diags...)
+}
+
+// Returns the diagnostics of severity reported so far, one per line
+func (tp *testProvider) diagnostics(severity tfprotov5.DiagnosticSeverity) string {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	var messages []string
+	for _, d := range tp.diags {
+		if d.Severity != severity {
+			continue
+		}
+		message := d.Summary
+		if d.Detail != "" {
+			message += ": " + d.Detail
+		}
+		messages = append(messages, message)
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Fails the test unless an error mentioning want was reported
+func (tp *testProvider) expectError(t *testing.T, want string) {
+	t.Helper()
+	if got := tp.diagnostics(tfprotov5.DiagnosticSeverityError); !strings.Contains(got, want) {
+		t.Errorf("expected an error containing %q, got %q", want, got)
+	}
+}
+
+// Fails the test unless a warning mentioning want was reported
+func (tp *testProvider) expectWarning(t *testing.T, want string) {
+	t.Helper()
+	if got := tp.diagnostics(tfprotov5.DiagnosticSeverityWarning); !strings.Contains(got, want) {
+		t.Errorf("expected a warning containing %q, got %q", want, got)
+	}
+}
+
+// Returns a check that the warnings reported since the last such check are
+// want, each mentioning the text given, in order
+func (tp *testProvider) checkWarnings(want ...string) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		tp.mu.Lock()
+		diags := tp.diags[tp.checked:]
+		tp.checked = len(tp.diags)
+		tp.mu.Unlock()
+		var got []string
+		for _, d := range diags {
+			if d.Severity == tfprotov5.DiagnosticSeverityWarning {
+				got = append(got, d.Summary+": "+d.Detail)
+			}
+		}
+		if len(got) != len(want) {
+			return fmt.Errorf("warned %q, expected %q", got, want)
+		}
+		for i := range want {
+			if !strings.Contains(got[i], want[i]) {
+				return fmt.Errorf("warned %q, expected %q", got, want)
+			}
+		}
+		return nil
+	}
+}
+
+// Fails the test if secret shows up in the diagnostics
+func (tp *testProvider) expectRedacted(t *testing.T, secret string) {
+	t.Helper()
+	for _, severity := range []tfprotov5.DiagnosticSeverity{tfprotov5.DiagnosticSeverityError, tfprotov5.DiagnosticSeverityWarning} {
+		if got := tp.diagnostics(severity); strings.Contains(got, secret) {
+			t.Errorf("diagnostics reveal a sensitive value: %q", got)
+		}
+	}
+}
+
+// recordingServer passes the diagnostics of the responses of a provider
+// server to its testProvider
+type recordingServer struct {
+	tfprotov5.ProviderServer
+	tp *testProvider
+}
+
+func (s *recordingServer) PrepareProviderConfig(ctx context.Context, req *tfprotov5.PrepareProviderConfigRequest) (*tfprotov5.PrepareProviderConfigResponse, error) {
+	resp, err := s.ProviderServer.PrepareProviderConfig(ctx, req)
+	if resp != nil {
+		s.tp.record(resp.Diagnostics)
+	}
+	return resp, err
+}
+
+func (s *recordingServer) ConfigureProvider(ctx context.Context, req *tfprotov5.ConfigureProviderRequest) (*tfprotov5.ConfigureProviderResponse, error) {
+	resp, err := s.ProviderServer.ConfigureProvider(ctx, req)
+	if resp != nil {
+		s.tp.record(resp.Diagnostics)
+	}
+	return resp, err
+}
+
+func (s *recordingServer) ValidateResourceTypeConfig(ctx context.Context, req *tfprotov5.ValidateResourceTypeConfigRequest) (*tfprotov5.ValidateResourceTypeConfigResponse, error) {
+	resp, err := s.ProviderServer.ValidateResourceTypeConfig(ctx, req)
+	if resp != nil {
+		s.tp.record(resp.Diagnostics)
+	}
+	return resp, err
+}
+
+func (s *recordingServer) PlanResourceChange(ctx context.Context, req *tfprotov5.PlanResourceChangeRequest) (*tfprotov5.PlanResourceChangeResponse, error) {
+	resp, err := s.ProviderServer.PlanResourceChange(ctx, req)
+	if resp != nil {
+		s.tp.record(resp.Diagnostics)
+	}
+	return resp, err
+}
+
+func (s *recordingServer) ApplyResourceChange(ctx context.Context, req *tfprotov5.ApplyResourceChangeRequest) (*tfprotov5.ApplyResourceChangeResponse, error) {
+	resp, err := s.ProviderServer.ApplyResourceChange(ctx, req)
+	if resp != nil {
+		s.tp.record(resp.Diagnostics)
+	}
+	return resp, err
+}
+
+func (s *recordingServer) ReadResource(ctx context.Context, req *tfprotov5.ReadResourceRequest) (*tfprotov5.ReadResourceResponse, error) {
+	resp, err := s.ProviderServer.ReadResource(ctx, req)
+	if resp != nil {
+		s.tp.record(resp.Diagnostics)
+	}
+	return resp, err
+}
+
+// Runs a test case with Terraform against a provider of its own, and returns
+// the provider for what it reported. After each step that applies, the plan
+// must be empty, and the resources are destroyed at the end.
+func runTestCase(t *testing.T, c resource.TestCase) *testProvider {
+	t.Helper()
+	tp := &testProvider{}
+	tp.test(t, c)
+	return tp
+}
+
+// Runs a test case against tp, for checks that look at what it reported
+func (tp *testProvider) test(t *testing.T, c resource.TestCase) {
+	t.Helper()
+	c.ProtoV5ProviderFactories = tp.factories()
+	resource.Test(t, c)
+}
+
+// Like runTestCase, for a case of steps alone
+func runSteps(t *testing.T, steps ...resource.TestStep) *testProvider {
+	t.Helper()
+	return runTestCase(t, resource.TestCase{Steps: steps})
+}
+
+// Returns a regular expression matching text in an error of Terraform, which
+// wraps long lines. Terraform writes the detail of a diagnostic below its
+// summary and the configuration it's about, so a colon in text matches
+// anything in between too.
+func errorMatching(text string) *regexp.Regexp {
+	words := strings.Fields(text)
+	for i, word := range words {
+		if strings.HasSuffix(word, ":") {
+			words[i] = regexp.QuoteMeta(strings.TrimSuffix(word, ":")) + `:?[\s\S]*?`
+			continue
+		}
+		words[i] = regexp.QuoteMeta(word)
+	}
+	return regexp.MustCompile(strings.Join(words, `\s+`))
+}
+
+// block is a nested block of a configuration, such as timeouts
+type block map[string]interface{}
+
+// hcl is an expression written into a configuration as it is, such as a
+// reference to another resource
+type hcl string
+
+// Returns the configuration of the provider with settings
+func providerConfig(settings map[string]interface{}) string {
+	return "provider \"camc\" {\n" + hclBody(settings, "  ") + "}\n"
+}
+
+// Returns the configuration of a resource of type typeName, named test
+func resourceConfig(typeName string, attributes map[string]interface{}) string {
+	return namedResourceConfig(typeName, "test", attributes)
+}
+
+func namedResourceConfig(typeName string, name string, attributes map[string]interface{}) string {
+	return fmt.Sprintf("resource %q %q {\n%s}\n", typeName, name, hclBody(attributes, "  "))
+}
+
+// Returns a copy of attributes with settings added
+func withSettings(attributes map[string]interface{}, settings map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(attributes)+len(settings))
+	for k, v := range attributes {
+		merged[k] = v
+	}
+	for k, v := range settings {
+		merged[k] = v
+	}
+	return merged
+}
+
+func hclBody(attributes map[string]interface{}, indent string) string {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		if nested, ok := attributes[k].(block); ok {
+			fmt.Fprintf(&b, "%s%s {\n%s%s}\n", indent, k, hclBody(nested, indent+"  "), indent)
+			continue
+		}
+		fmt.Fprintf(&b, "%s%s = %s\n", indent, k, hclValue(attributes[k], indent))
+	}
+	return b.String()
+}
+
+func hclValue(v interface{}, indent string) string {
+	switch v := v.(type) {
+	case hcl:
+		return string(v)
+	case string:
+		// a JSON string is an HCL one, once templates are escaped
+		b, _ := json.Marshal(v)
+		return strings.NewReplacer("${", "$${", "%{", "%%{").Replace(string(b))
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = hclValue(item, indent)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case []string:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = hclValue(item, indent)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		b.WriteString("{\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s  %s = %s\n", indent, hclValue(k, indent), hclValue(v[k], indent+"  "))
+		}
+		b.WriteString(indent + "}")
+		return b.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// Returns attribute key of the resource at address, such as
+// camc_bootstrap.test, in state s
+func stateAttr(s *terraform.State, address string, key string) string {
+	r, ok := s.RootModule().Resources[address]
+	if !ok || r.Primary == nil {
+		return ""
+	}
+	return r.Primary.Attributes[key]
+}
+
+// Returns a check that the pattern manager was sent the requests want, such
+// as "POST /bootstrap", since the requests were last looked at
+func checkRequests(stub *patternManagerStub, want ...string) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		var got []string
+		for _, request := range stub.Requests() {
+			got = append(got, request.Method+" "+request.Path)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			return fmt.Errorf("pattern manager was sent %q, expected %q", got, want)
+		}
+		return nil
+	}
+}
+
+// Takes one of the pattern manager resources through its lifecycle. Set
+// deletes when destroying the resource sends a DELETE.
+func testPatternManagerResource(t *testing.T, name string, deletes bool) {
+	stub := newPatternManagerStub(t)
+	address := name + ".test"
+	config := map[string]interface{}{
+		"camc_endpoint": stub.URL,
+		"access_token":  "access-token-1234",
+		"data":          `{"name":"first"}`,
+	}
+	files := newTestTLSFiles(t)
+	replaced := []string{"POST /"}
+	var destroyed []string
+	if deletes {
+		replaced = []string{"DELETE /", "POST /"}
+		destroyed = []string{"DELETE /"}
+	}
+
+	runTestCase(t, resource.TestCase{
+		Steps: []resource.TestStep{
+			{
+				Config: resourceConfig(name, config),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrSet(address, "id"),
+					func(*terraform.State) error {
+						requests := stub.Requests()
+						if len(requests) != 1 || requests[0].Method != "POST" {
+							return fmt.Errorf("create sent %+v, expected a POST", requests)
+						}
+						if requests[0].Authorization != "Bearer access-token-1234" {
+							return fmt.Errorf("create was authorized with %q", requests[0].Authorization)
+						}
+						if !strings.Contains(requests[0].UserAgent, "terraform-provider-camc/"+version) {
+							return fmt.Errorf("create was sent with the User-Agent %q", requests[0].UserAgent)
+						}
+						if !strings.Contains(requests[0].Body, `"first"`) {
+							return fmt.Errorf("create sent %q, expected the data", requests[0].Body)
+						}
+						return nil
+					},
+				),
+			},
+			// trace only changes the logging
+			{
+				Config: resourceConfig(name, withSettings(config, map[string]interface{}{"trace": true})),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(address, "trace", "true"),
+					checkRequests(stub),
+				),
+			},
+			// the TLS settings are changed in place, from files to inline PEM
+			// and back
+			{
+				Config: resourceConfig(name, withSettings(config, map[string]interface{}{"ca_file": files.certFile, "cert_file": files.certFile, "key_file": files.keyFile})),
+				Check:  checkRequests(stub),
+			},
+			{
+				Config: resourceConfig(name, withSettings(config, map[string]interface{}{"ca_pem": files.certPEM, "cert_pem": files.certPEM, "key_pem": files.keyPEM, "min_tls_version": "1.3"})),
+				Check:  checkRequests(stub),
+			},
+			// the data can't be updated, so the resource is replaced
+			{
+				Config: resourceConfig(name, withSettings(config, map[string]interface{}{"data": `{"name":"second"}`})),
+				Check:  checkRequests(stub, replaced...),
+			},
+		},
+		CheckDestroy: checkRequests(stub, destroyed...),
+	})
+
+	// the error body is reported with the secrets masked
+	stub.Respond(500, `{"message":"login with secret-password-1234 failed"}`)
+	config = map[string]interface{}{
+		"camc_endpoint": stub.URL,
+		"auth_mode":     "basic",
+		"username":      "admin",
+		"password":      "secret-password-1234",
+		"data":          `{"name":"first"}`,
+	}
+	tp := runSteps(t, resource.TestStep{
+		Config:      resourceConfig(name, config),
+		ExpectError: errorMatching("POST / returned 500 Internal Server Error"),
+	})
+	tp.expectError(t, "login with ****** failed")
+	tp.expectRedacted(t, "secret-password-1234")
+
+	// data must be JSON
+	runSteps(t, resource.TestStep{
+		Config:      resourceConfig(name, withSettings(config, map[string]interface{}{"data": "not json"})),
+		ExpectError: errorMatching("Value is not valid json"),
+	})
+	if requests := stub.Requests(); len(requests) != 1 {
+		t.Fatalf("invalid data sent %+v", requests)
+	}
+
+	t.Run("TLS", func(t *testing.T) { testPatternManagerTLS(t, name) })
+	t.Run("MTLS", func(t *testing.T) { testPatternManagerMTLS(t, name) })
+	t.Run("request errors", func(t *testing.T) { testPatternManagerRequestErrors(t, name) })
+}
+
+// Writes the certificate of a stand-in server to a file for ca_file and
+// returns its path
+func writeCAFile(t *testing.T, certificatePEM string) string {
+	t.Helper()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte(certificatePEM), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return caFile
+}
+
+// A client certificate for the TLS settings, inline and in files, along with
+// the files a bad setting points at
+type testTLSFiles struct {
+	certPEM   string
+	keyPEM    string
+	clientCAs *x509.CertPool
+	certFile  string
+	keyFile   string
+	notPEM    string
+	missing   string
+}
+
+func newTestTLSFiles(t *testing.T) *testTLSFiles {
+	t.Helper()
+	f := &testTLSFiles{}
+	f.certPEM, f.keyPEM, f.clientCAs = newTestClientCertificate(t)
+	dir := t.TempDir()
+	f.certFile = filepath.Join(dir, "cert.pem")
+	f.keyFile = filepath.Join(dir, "key.pem")
+	f.notPEM = filepath.Join(dir, "not.pem")
+	f.missing = filepath.Join(dir, "missing.pem")
+	for file, content := range map[string]string{f.certFile: f.certPEM, f.keyFile: f.keyPEM, f.notPEM: "not pem"} {
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// A configuration a request fails for and the error it fails with
+type requestErrorCase struct {
+	name     string
+	settings map[string]interface{}
+	err      string
+}
+
+// Returns the cases of every resource that sends requests to endpoint: a
+// server that's closed and one that truncates the response
+func transportErrorCases(t *testing.T, endpoint string) []requestErrorCase {
+	closed := newPatternManagerStub(t)
+	closed.Close()
+	return []requestErrorCase{
+		{"transport error", map[string]interface{}{endpoint: closed.URL}, "Unable to connect to"},
+		{"truncated body", map[string]interface{}{endpoint: newTruncatingServer(t).URL}, "Error reading the response"},
+	}
+}
+
+// Runs each case, its settings over config, for a resource of typeName, and
+// checks that stub wasn't sent anything. Failures used to call log.Fatal or
+// panic, which killed the plugin process, or were ignored.
+func testRequestErrors(t *testing.T, typeName string, stub *patternManagerStub, config map[string]interface{}, cases []requestErrorCase) {
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runSteps(t, resource.TestStep{
+				Config:      resourceConfig(typeName, withSettings(config, c.settings)),
+				ExpectError: errorMatching(c.err),
+			})
+		})
+	}
+	if requests := stub.Requests(); len(requests) != 0 {
+		t.Fatalf("pattern manager was sent %+v", requests)
+	}
+}
+
+// Checks the server verification settings of a pattern manager resource
+func testPatternManagerTLS(t *testing.T, name string) {
+	stub := newPatternManagerStubTLS(t, &tls.Config{MaxVersion: tls.VersionTLS12})
+	caFile := writeCAFile(t, stub.CertificatePEM())
+	config := func(resourceName string, settings map[string]interface{}) string {
+		settings["camc_endpoint"] = stub.URL
+		settings["access_token"] = "token-1234"
+		return namedResourceConfig(name, resourceName, settings)
+	}
+
+	// a private CA can be trusted on its own, from a file or inline, and
+	// skipping verification still works, with a warning
+	tp := &testProvider{}
+	tp.test(t, resource.TestCase{
+		Steps: []resource.TestStep{{
+			Config: config("file", map[string]interface{}{"ca_file": caFile}) +
+				config("inline", map[string]interface{}{"ca_pem": stub.CertificatePEM()}) +
+				config("server_name", map[string]interface{}{
+					"ca_pem":          stub.CertificatePEM(),
+					"tls_server_name": "example.com",
+				}) +
+				config("skip", map[string]interface{}{"skip_ssl_verify": true}),
+			Check: resource.ComposeTestCheckFunc(
+				tp.checkWarnings("TLS certificate verification is disabled"),
+				checkRequests(stub, "POST /", "POST /", "POST /", "POST /"),
+			),
+		}},
+	})
+
+	stub.Requests()
+	runSteps(t,
+		// certificates are verified by default
+		resource.TestStep{
+			Config:      config("test", map[string]interface{}{}),
+			ExpectError: errorMatching("certificate"),
+		},
+		resource.TestStep{
+			Config: config("test", map[string]interface{}{
+				"ca_pem":          stub.CertificatePEM(),
+				"tls_server_name": "camc.invalid",
+			}),
+			ExpectError: errorMatching("camc.invalid"),
+		},
+		// the stub only speaks TLS 1.2
+		resource.TestStep{
+			Config: config("test", map[string]interface{}{
+				"ca_pem":          stub.CertificatePEM(),
+				"min_tls_version": "1.3",
+			}),
+			ExpectError: errorMatching("protocol version"),
+		},
+		resource.TestStep{
+			Config:      config("test", map[string]interface{}{"ca_pem": "not pem"}),
+			ExpectError: errorMatching("Value is not PEM encoded"),
+		},
+	)
+	if requests := stub.Requests(); len(requests) != 0 {
+		t.Errorf("pattern manager was sent %d requests", len(requests))
+	}
+}
+
+// Checks that a pattern manager resource presents its client certificate,
+// inline or from files, with auth_mode mtls
+func testPatternManagerMTLS(t *testing.T, name string) {
+	files := newTestTLSFiles(t)
+	stub := newPatternManagerStubTLS(t, &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  files.clientCAs,
+	})
+
+	var config string
+	for i, settings := range []map[string]interface{}{
+		{"cert_pem": files.certPEM, "key_pem": files.keyPEM},
+		{"cert_file": files.certFile, "key_file": files.keyFile},
+		{"cert_file": files.certFile, "key_pem": files.keyPEM},
+	} {
+		settings["camc_endpoint"] = stub.URL
+		settings["auth_mode"] = "mtls"
+		settings["ca_pem"] = stub.CertificatePEM()
+		config += namedResourceConfig(name, fmt.Sprintf("test%d", i), settings)
+	}
+	runSteps(t, resource.TestStep{
+		Config: config,
+		Check: func(*terraform.State) error {
+			requests := stub.Requests()
+			if len(requests) != 3 {
+				return fmt.Errorf("pattern manager was sent %+v", requests)
+			}
+			for _, request := range requests {
+				if request.Authorization != "" {
+					return fmt.Errorf("mtls sent Authorization %q", request.Authorization)
+				}
+			}
+			return nil
+		},
+	})
+
+	// without the certificate the handshake fails
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig(name, map[string]interface{}{
+			"camc_endpoint": stub.URL,
+			"access_token":  "token-1234",
+			"ca_pem":        stub.CertificatePEM(),
+		}),
+		ExpectError: errorMatching("Unable to connect to endpoint"),
+	})
+}
+
+// Checks the errors of a pattern manager resource that's misconfigured or
+// whose request fails
+func testPatternManagerRequestErrors(t *testing.T, name string) {
+	files := newTestTLSFiles(t)
+	stub := newPatternManagerStub(t)
+	testRequestErrors(t, name, stub, map[string]interface{}{
+		"camc_endpoint": stub.URL,
+		"access_token":  "token-1234",
+	}, append([]requestErrorCase{
+		{"unreadable cert_file", map[string]interface{}{"cert_file": files.missing, "key_pem": files.keyPEM}, "Error reading cert_file"},
+		{"unreadable ca_file", map[string]interface{}{"ca_file": files.missing}, "Error reading ca_file"},
+		{"ca_file without PEM", map[string]interface{}{"ca_file": files.notPEM}, "ca_file contains no PEM certificates"},
+		{"mismatched client certificate", map[string]interface{}{"cert_file": files.notPEM, "key_pem": files.keyPEM}, "Error loading the client certificate"},
+		{"invalid endpoint", map[string]interface{}{"camc_endpoint": "http://bad host/"}, "Invalid camc_endpoint"},
+		{"relative endpoint", map[string]interface{}{"camc_endpoint": "bootstrap"}, "the provider has no endpoint to resolve it against"},
+	}, transportErrorCases(t, "camc_endpoint")...))
+}
@@ -6,24 +6,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"net/http"
 
+	"github.com/IBM-CAMHub-Open/terraform-provider-camc/common"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func resourceCAMC() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceCAMCCreate,
-		Read:   resourceCAMCRead,
-		Update: resourceCAMCUpdate,
-		Delete: resourceCAMCDelete,
+		CreateContext: resourceCAMCCreate,
+		ReadContext:   resourceCAMCRead,
+		UpdateContext: resourceCAMCUpdate,
+		DeleteContext: resourceCAMCDelete,
 
 		Schema: map[string]*schema.Schema{
 			"name": &schema.Schema{
@@ -65,11 +65,12 @@ func resourceCAMC() *schema.Resource {
 			},
 
 			"payload": &schema.Schema{
-				Type:      schema.TypeString,
-				Optional:  true,
-				StateFunc: jsonStateFunc,
-				Default:   "null",
-				ForceNew:  true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "null",
+				ForceNew:         true,
+				ValidateDiagFunc: common.ValidateJSON,
+				DiffSuppressFunc: common.SuppressEquivalentJSON,
 			},
 
 			"username": &schema.Schema{
@@ -80,10 +81,11 @@ func resourceCAMC() *schema.Resource {
 			},
 
 			"password": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
-				Default:  "",
-				ForceNew: true,
+				Type:      schema.TypeString,
+				Optional:  true,
+				Default:   "",
+				ForceNew:  true,
+				Sensitive: true,
 			},
 
 			"skip_ssl_verify": &schema.Schema{
@@ -118,282 +120,169 @@ func resourceCAMC() *schema.Resource {
 				Type:     schema.TypeBool,
 				Optional: true,
 				Default:  false,
-				ForceNew: true,
 			},
-		},
-	}
-}
-
-func traceMessage(d *schema.ResourceData, msg string) {
-	if d.Get("trace").(bool) == true {
-		log.SetFlags(0)
-		log.Print(msg)
-	}
-}
 
-func traceMessagef(d *schema.ResourceData, fmt string, msg string) {
-	if d.Get("trace").(bool) == true {
-		log.SetFlags(0)
-		log.Printf(fmt, msg)
+			"expected_status_codes": common.ExpectedStatusCodesSchema(common.OperationCreate, common.OperationRead, common.OperationUpdate, common.OperationDelete),
+		},
 	}
 }
 
-func makeCreateRequest(d *schema.ResourceData, m interface{}, url string) (string, error) {
-	//get all possible inputs
+// Sends body to url for operation and returns the response body
+func sendCAMCRequest(ctx context.Context, d *schema.ResourceData, m interface{}, operation string, url string, body *bytes.Buffer) (string, error) {
 	method := d.Get("method").(string)
-	payload := d.Get("payload").(string)
 	username := d.Get("username").(string)
 	password := d.Get("password").(string)
-	certFile := d.Get("cert_file").(string)
-	keyFile := d.Get("key_file").(string)
-	caFile := d.Get("ca_file").(string)
-	skip_ssl_verify := d.Get("skip_ssl_verify").(bool)
-
-	//initialize a tlsConfig structure
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: skip_ssl_verify,
+
+	client, err := common.TLSClient(d, m)
+	if err != nil {
+		return "", err
 	}
 
-	//if client cert information provided use it to setup the tlsConfig structure
-	if certFile != "" && keyFile != "" && caFile != "" {
-		traceMessage(d, "**********  start using client cert connectivity")
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	send := func(method string, url string, body io.Reader) (*http.Response, string, error) {
+		//setup the http request
+		req, err := http.NewRequestWithContext(ctx, method, url, body)
 		if err != nil {
-			log.Fatal(err)
+			return nil, "", common.NewError(d, "Invalid request to "+url, err.Error())
 		}
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Accept", "application/json")
+		req.Header.Set("User-Agent", common.UserAgent(m))
 
-		// Load CA cert
-		caCert, err := ioutil.ReadFile(caFile)
-		if err != nil {
-			log.Fatal(err)
+		if username != "" && password != "" {
+			req.SetBasicAuth(username, password)
 		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
 
-		// Setup HTTPS client
-		tlsConfig.Certificates = []tls.Certificate{cert}
-		tlsConfig.RootCAs = caCertPool
-		tlsConfig.BuildNameToCertificate()
-	} else {
-		traceMessage(d, "**********  skip using client cert connectivity, certfile, keyfile and caFile not passed in as args")
-	}
-
-	//initialize the http transport
-	tr := &http.Transport{
-		TLSClientConfig: tlsConfig,
-	}
-
-	//initialize the http client with the defined transport
-	client := &http.Client{
-		Transport: tr,
-	}
-
-	//process the input payload
-	var x map[string]interface{}
-
-	if payload != "null" {
-		json.Unmarshal([]byte(payload), &x)
-	}
-
-	b := new(bytes.Buffer)
-	if len(x) != 0 {
-		json.NewEncoder(b).Encode(x)
-	}
-
-	//setup the http request
-	req, _ := http.NewRequest(method, url, b)
-	req.Close = true
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
+		//make the call
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, "", common.NewError(d, "Unable to connect to "+url, err.Error())
+		}
 
-	if username != "" && password != "" {
-		req.SetBasicAuth(username, password)
+		//access the response body
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, "", common.NewError(d, "Error reading the response from "+url, err.Error())
+		}
+		return resp, string(respBody), nil
 	}
 
-	//make the call
-	resp, err := client.Do(req)
+	resp, rb, err := send(method, url, body)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
-	//access the response body
-	defer resp.Body.Close()
-	respBody, _ := ioutil.ReadAll(resp.Body)
-
-	rb := string(respBody)
-
 	// don't save the payload, may have secrets
-	//traceMessage(d, "[trace] setting payload to 'null' so not stored in state file")
+	//common.TraceMessage(ctx, d, common.SubsystemHTTP, "setting payload to 'null' so not stored in state file")
 	//d.Set("payload", "null")
 
-	if resp.StatusCode == 200 || resp.StatusCode == 201 {
-		traceMessage(d, fmt.Sprintf("**********  good response StatusCode --> %v, body --> %s", resp.StatusCode, rb))
+	if common.IsGone(operation, resp.StatusCode) {
+		common.TraceMessage(ctx, d, common.SubsystemHTTP, "already deleted", map[string]interface{}{"url": url, "status_code": resp.StatusCode})
 		return rb, nil
-	} else {
+	}
+	if !common.ExpectedStatus(d, operation, resp.StatusCode) {
 		//return all errors
-		traceMessage(d, fmt.Sprintf("**********  bad response StatusCode --> %v, body --> %s", resp.StatusCode, rb))
-		err := fmt.Errorf("\n%s", rb)
-		return "", err
+		common.TraceMessage(ctx, d, common.SubsystemHTTP, "bad response", map[string]interface{}{"url": url, "status_code": resp.StatusCode, "body": rb})
+		return "", common.ResponseError(d, resp, rb)
 	}
+	common.TraceMessage(ctx, d, common.SubsystemHTTP, "good response", map[string]interface{}{"url": url, "status_code": resp.StatusCode, "body": rb})
+	_, rb, err = common.PollOperation(ctx, d, operation, resp, rb, func(url string) (*http.Response, string, error) {
+		return send(http.MethodGet, url, nil)
+	})
+	return rb, err
 }
 
-func makeRequest(d *schema.ResourceData, m interface{}, url string) (string, error) {
-	//get all possible inputs
-	name := d.Get("name").(string)
-	method := d.Get("method").(string)
-	username := d.Get("username").(string)
-	password := d.Get("password").(string)
-	certFile := d.Get("cert_file").(string)
-	keyFile := d.Get("key_file").(string)
-	caFile := d.Get("ca_file").(string)
-	skip_ssl_verify := d.Get("skip_ssl_verify").(bool)
-
-	//initialize a tlsConfig structure
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: skip_ssl_verify,
-	}
-
-	//if client cert information provided use it to setup the tlsConfig structure
-	if certFile != "" && keyFile != "" && caFile != "" {
-		traceMessage(d, "**********  start using client cert connectivity")
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			log.Fatal(err)
-		}
+func makeCreateRequest(ctx context.Context, d *schema.ResourceData, m interface{}, url string) (string, error) {
+	ctx = common.LoggingContext(ctx, d)
 
-		// Load CA cert
-		caCert, err := ioutil.ReadFile(caFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-
-		// Setup HTTPS client
-		tlsConfig.Certificates = []tls.Certificate{cert}
-		tlsConfig.RootCAs = caCertPool
-		tlsConfig.BuildNameToCertificate()
-	} else {
-		traceMessage(d, "**********  skip using client cert connectivity, certfile, keyfile and caFile not passed in as args")
-	}
-
-	//initialize the http transport
-	tr := &http.Transport{
-		TLSClientConfig: tlsConfig,
+	//process the input payload
+	payload, err := common.JSONRequestBody(d, "payload")
+	if err != nil {
+		return "", err
 	}
+	b := bytes.NewBuffer(payload)
+	return sendCAMCRequest(ctx, d, m, common.OperationCreate, url, b)
+}
 
-	//initialize the http client with the defined transport
-	client := &http.Client{
-		Transport: tr,
-	}
+func makeRequest(ctx context.Context, d *schema.ResourceData, m interface{}, operation string, url string) (string, error) {
+	ctx = common.LoggingContext(ctx, d)
+	name := d.Get("name").(string)
 
-	traceMessagef(d, "**********  using name attribute --> %s", name)
+	common.TraceMessage(ctx, d, common.SubsystemHTTP, "using name attribute", map[string]interface{}{"name": name})
 	resource := fmt.Sprintf("{\"resourceID\":\"%s\"}", name)
 
 	b := new(bytes.Buffer)
 	if len(resource) != 0 {
 		json.NewEncoder(b).Encode(resource)
 	}
-
-	//setup the http request
-	req, _ := http.NewRequest(method, url, b)
-	req.Close = true
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-
-	if username != "" && password != "" {
-		req.SetBasicAuth(username, password)
-	}
-
-	//make the call
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
-	}
-
-	//access the response body
-	defer resp.Body.Close()
-	respBody, _ := ioutil.ReadAll(resp.Body)
-
-	rb := string(respBody)
-
-	// don't save the payload, may have secrets
-	//d.Set("payload", "null")
-
-	if resp.StatusCode == 200 || resp.StatusCode == 201 {
-		traceMessage(d, fmt.Sprintf("**********  good response StatusCode --> %v, body --> %s", resp.StatusCode, rb))
-		return rb, nil
-	} else {
-		//return all errors
-		traceMessage(d, fmt.Sprintf("**********  bad response StatusCode --> %v, body --> %s", resp.StatusCode, rb))
-		err := fmt.Errorf("\n%s", rb)
-		return "", err
-	}
+	return sendCAMCRequest(ctx, d, m, operation, url, b)
 }
 
-func resourceCAMCCreate(d *schema.ResourceData, m interface{}) error {
+func resourceCAMCCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	//get create url
 	url := d.Get("url").(string)
 	if url != "" {
-		_, err := makeCreateRequest(d, m, url)
+		_, err := makeCreateRequest(ctx, d, m, url)
 		if err == nil {
 			b := make([]byte, 16)
 			_, err := rand.Read(b)
 			if err != nil {
-				fmt.Println("Error:", err)
-				return nil
+				return diag.Errorf("Error generating the resource id: %s", err)
 			}
 			uuid := fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 			d.SetId(uuid)
 			return nil
 		} else {
-			return err
+			return common.Diagnostics(err)
 		}
 	} else {
 		return nil
 	}
 }
 
-func resourceCAMCRead(d *schema.ResourceData, m interface{}) error {
+func resourceCAMCRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	//get read url
 	url := d.Get("read_url").(string)
 	if url != "" {
-		_, err := makeRequest(d, m, url)
+		_, err := makeRequest(ctx, d, m, common.OperationRead, url)
 		if err == nil {
 			return nil
 		} else {
-			return err
+			return common.Diagnostics(err)
 		}
 	} else {
 		return nil
 	}
 }
 
-func resourceCAMCUpdate(d *schema.ResourceData, m interface{}) error {
+func resourceCAMCUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if !d.HasChangesExcept("trace") {
+		return nil
+	}
 	//get update url
 	url := d.Get("update_url").(string)
 	if url != "" {
-		_, err := makeRequest(d, m, url)
+		_, err := makeRequest(ctx, d, m, common.OperationUpdate, url)
 		if err == nil {
 			return nil
 		} else {
-			return err
+			return common.Diagnostics(err)
 		}
 	} else {
 		return nil
 	}
 }
 
-func resourceCAMCDelete(d *schema.ResourceData, m interface{}) error {
+func resourceCAMCDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	//get delete url
 	url := d.Get("delete_url").(string)
 	if url != "" {
-		_, err := makeRequest(d, m, url)
+		_, err := makeRequest(ctx, d, m, common.OperationDelete, url)
 		if err == nil {
 			d.SetId("")
 			return nil
 		} else {
-			return err
+			return common.Diagnostics(err)
 		}
 	} else {
 		return nil
@@ -5,16 +5,21 @@
 package main
 
 import (
+	"context"
+
 	"github.com/IBM-CAMHub-Open/terraform-provider-camc/common"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 func resourceCamcScriptPackage() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceCamcScriptPackageCreate,
-		Read:   resourceCamcScriptPackageRead,
-		Update: resourceCamcScriptPackageUpdate,
-		Delete: resourceCamcScriptPackageDelete,
+		CreateContext: resourceCamcScriptPackageCreate,
+		ReadContext:   resourceCamcScriptPackageRead,
+		UpdateContext: resourceCamcScriptPackageUpdate,
+		DeleteContext: resourceCamcScriptPackageDelete,
+		CustomizeDiff: common.ScriptPackageCustomizeDiff,
 
 		Schema: map[string]*schema.Schema{
 			"program": &schema.Schema{
@@ -37,6 +42,13 @@ func resourceCamcScriptPackage() *schema.Resource {
 				Sensitive: true,
 			},
 
+			"raw_command": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+
 			"source": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
@@ -82,6 +94,34 @@ func resourceCamcScriptPackage() *schema.Resource {
 				Sensitive: true,
 			},
 
+			"environment": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				ForceNew:         true,
+				ValidateDiagFunc: common.ValidateEnvironment,
+			},
+
+			"environment_sensitive": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:      schema.TypeString,
+					Sensitive: true,
+				},
+				ForceNew:         true,
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidateEnvironment,
+			},
+
+			"working_dir": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
 			"result": &schema.Schema{
 				Type:     schema.TypeMap,
 				Computed: true,
@@ -128,12 +168,63 @@ func resourceCamcScriptPackage() *schema.Resource {
 			},
 
 			"remote_key": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				ForceNew:         true,
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidatePrivateKey,
+			},
+
+			"become": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+
+			"become_user": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"become_password": &schema.Schema{
 				Type:      schema.TypeString,
 				Optional:  true,
 				ForceNew:  true,
 				Sensitive: true,
 			},
 
+			"request_pty": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+
+			"pty_term": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "xterm",
+				ForceNew: true,
+			},
+
+			"pty_width": &schema.Schema{
+				Type:             schema.TypeInt,
+				Optional:         true,
+				Default:          80,
+				ForceNew:         true,
+				ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+			},
+
+			"pty_height": &schema.Schema{
+				Type:             schema.TypeInt,
+				Optional:         true,
+				Default:          24,
+				ForceNew:         true,
+				ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+			},
+
 			"bastion_host": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
@@ -154,13 +245,21 @@ func resourceCamcScriptPackage() *schema.Resource {
 			},
 
 			"bastion_private_key": &schema.Schema{
-				Type:      schema.TypeString,
-				Optional:  true,
-				ForceNew:  true,
-				Sensitive: true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				ForceNew:         true,
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidatePrivateKey,
 			},
 
 			"bastion_port": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				ForceNew:         true,
+				ValidateDiagFunc: common.ValidatePort,
+			},
+
+			"log_file": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
 				ForceNew: true,
@@ -170,7 +269,6 @@ func resourceCamcScriptPackage() *schema.Resource {
 				Type:     schema.TypeBool,
 				Optional: true,
 				Default:  false,
-				ForceNew: true,
 			},
 
 			"source_no_check_cert": &schema.Schema{
@@ -183,7 +281,7 @@ func resourceCamcScriptPackage() *schema.Resource {
 	}
 }
 
-func resourceCamcScriptPackageCreate(d *schema.ResourceData, m interface{}) error {
+func resourceCamcScriptPackageCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	if !d.Get("on_create").(bool) {
 		// Need to set an ID so that the resource gets created in Terraform
 		d.SetId(common.GenUUID())
@@ -192,48 +290,54 @@ func resourceCamcScriptPackageCreate(d *schema.ResourceData, m interface{}) erro
 		d.Set("result", emptyResult)
 		return nil
 	}
-	result, err := common.RunScript(d, m)
+	// set ahead so the output of the program is logged with it
+	d.SetId(common.GenUUID())
+	result, diags := common.RunScript(ctx, d, m)
 
-	if err != nil {
-		return err
+	if diags.HasError() {
+		d.SetId("")
+		return diags
 	}
 
 	d.Set("result", result)
-	d.SetId(common.GenUUID())
-	return nil
+	return diags
 }
 
-func resourceCamcScriptPackageRead(d *schema.ResourceData, m interface{}) error {
+func resourceCamcScriptPackageRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	return nil
 }
 
-func resourceCamcScriptPackageUpdate(d *schema.ResourceData, m interface{}) error {
+func resourceCamcScriptPackageUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	// trace only changes how much is logged, it doesn't run the script again
+	if !d.HasChangesExcept("trace") {
+		return nil
+	}
 	if !d.Get("on_update").(bool) {
 		var emptyResult map[string]string
 		emptyResult = make(map[string]string)
 		d.Set("result", emptyResult)
 		return nil
 	}
-	return runRequest(d, m)
+	return runRequest(ctx, d, m)
 }
 
-func resourceCamcScriptPackageDelete(d *schema.ResourceData, m interface{}) error {
+func resourceCamcScriptPackageDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	if !d.Get("on_delete").(bool) {
 		var emptyResult map[string]string
 		emptyResult = make(map[string]string)
 		d.Set("result", emptyResult)
 		return nil
 	}
-	return runRequest(d, m)
+	return runRequest(ctx, d, m)
 }
 
-func runRequest(d *schema.ResourceData, m interface{}) error {
-	result, err := common.RunScript(d, m)
+func runRequest(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	result, diags := common.RunScript(ctx, d, m)
 
-	if err != nil {
-		return err
+	if diags.HasError() {
+		return diags
 	}
 
 	d.Set("result", result)
-	return nil
+	return diags
 }
@@ -0,0 +1,1007 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// A program that counts its runs in the file runs of its working directory
+const countingProgram = `echo run >> runs; printf '{"greeting":"hello %s","runs":"%s"}' "$NAME" "$(wc -l < runs | tr -d ' ')"`
+
+// Returns config with server as its remote host, reached by its name
+func remoteConfig(server *sshTestServer, config map[string]interface{}) map[string]interface{} {
+	config["remote_host"] = server.name
+	config["remote_user"] = testSSHUser
+	if _, ok := config["remote_key"]; !ok {
+		config["remote_password"] = testSSHPassword
+	}
+	return config
+}
+
+// Returns the configuration of a provider that reaches the ssh test servers
+// by name, through a SOCKS5 proxy stub
+func remoteProviderConfig(t *testing.T) string {
+	return providerConfig(map[string]interface{}{"ssh_proxy": newSOCKS5ProxyStub(t, "", "").URL})
+}
+
+// Serves script at /script.sh
+func newScriptServer(t *testing.T, script string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/script.sh" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(script))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestResourceCamcScriptPackageLocal(t *testing.T) {
+	dir := t.TempDir()
+	address := "camc_scriptpackage.test"
+	config := map[string]interface{}{
+		"program":     []interface{}{"sh", "-c", countingProgram},
+		"working_dir": dir,
+		"environment": map[string]interface{}{"NAME": "world"},
+		"on_create":   true,
+		"on_delete":   true,
+	}
+
+	runTestCase(t, resource.TestCase{
+		Steps: []resource.TestStep{
+			{
+				Config: resourceConfig("camc_scriptpackage", config),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(address, "result.greeting", "hello world"),
+					resource.TestCheckResourceAttr(address, "result.runs", "1"),
+				),
+			},
+			// trace doesn't run the program again
+			{
+				Config: resourceConfig("camc_scriptpackage", withSettings(config, map[string]interface{}{"trace": true})),
+				Check:  resource.TestCheckResourceAttr(address, "result.runs", "1"),
+			},
+			// the program runs on delete and again on create when it's replaced
+			{
+				Config: resourceConfig("camc_scriptpackage", withSettings(config, map[string]interface{}{
+					"environment": map[string]interface{}{"NAME": "again"},
+				})),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(address, "result.greeting", "hello again"),
+					resource.TestCheckResourceAttr(address, "result.runs", "3"),
+				),
+			},
+		},
+		CheckDestroy: func(*terraform.State) error {
+			runs, _ := os.ReadFile(filepath.Join(dir, "runs"))
+			if n := strings.Count(string(runs), "run\n"); n != 4 {
+				return fmt.Errorf("the program ran %d times", n)
+			}
+			return nil
+		},
+	})
+}
+
+func TestResourceCamcScriptPackageLocalDownload(t *testing.T) {
+	ts := newScriptServer(t, `printf '{"from":"download"}'`)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a local download goes to the working directory of the provider
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	config := map[string]interface{}{
+		"program":     []interface{}{"sh", "downloaded.sh"},
+		"source":      ts.URL + "/script.sh",
+		"destination": "downloaded.sh",
+		"on_create":   true,
+	}
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig("camc_scriptpackage", config),
+		Check:  resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.from", "download"),
+	})
+	os.Remove("downloaded.sh")
+
+	// unless working_dir is set, where the program runs
+	dir := t.TempDir()
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig("camc_scriptpackage", withSettings(config, map[string]interface{}{"working_dir": dir})),
+		Check: resource.ComposeTestCheckFunc(
+			resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.from", "download"),
+			func(*terraform.State) error {
+				if _, err := os.Stat(filepath.Join(dir, "downloaded.sh")); err != nil {
+					return fmt.Errorf("source wasn't downloaded to working_dir: %s", err)
+				}
+				if _, err := os.Stat("downloaded.sh"); err == nil {
+					return fmt.Errorf("source was downloaded to the working directory of the provider")
+				}
+				return nil
+			},
+		),
+	})
+}
+
+func TestResourceCamcScriptPackageRemote(t *testing.T) {
+	server := newSSHTestServer(t)
+	runSteps(t, resource.TestStep{
+		Config: remoteProviderConfig(t) + resourceConfig("camc_scriptpackage", remoteConfig(server, map[string]interface{}{
+			"program":     []interface{}{"cat"},
+			"query":       map[string]interface{}{"answer": "42"},
+			"environment": map[string]interface{}{"NAME": "world"},
+			"on_create":   true,
+		})),
+		Check: resource.ComposeTestCheckFunc(
+			// the query is sent on stdin
+			resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.answer", "42"),
+			func(*terraform.State) error {
+				if commands := server.Commands(); len(commands) != 1 || commands[0] != "cat" {
+					return fmt.Errorf("remote host ran %q", commands)
+				}
+				if env := server.Env(); len(env) != 1 || env[0] != "NAME" {
+					return fmt.Errorf("remote host was sent the environment %q", env)
+				}
+				return nil
+			},
+		),
+	})
+}
+
+// A program that prints its environment, working directory and first
+// argument, passed after it
+var environmentProgram = []interface{}{"sh", "-c", `printf '{"name":"%s","literal":"%s","token":"%s","inherited":"%s","pwd":"%s","arg":"%s"}' "$NAME" "$LITERAL" "$TOKEN" "$CAMC_TEST_INHERITED" "$(pwd -P)" "$1"`, "sh", "two words"}
+
+// Returns a check that the environmentProgram was run with the
+// environmentSettings, in pwd, and saw inherited as the provider's variable
+func checkEnvironment(address string, pwd string, inherited string) resource.TestCheckFunc {
+	return resource.ComposeTestCheckFunc(
+		resource.TestCheckResourceAttr(address, "result.name", "sensitive"),
+		resource.TestCheckResourceAttr(address, "result.literal", "it's $HOME *"),
+		resource.TestCheckResourceAttr(address, "result.token", "token 1234"),
+		resource.TestCheckResourceAttr(address, "result.inherited", inherited),
+		resource.TestCheckResourceAttr(address, "result.pwd", pwd),
+		resource.TestCheckResourceAttr(address, "result.arg", "two words"),
+	)
+}
+
+// The settings of the environmentProgram. Sensitive variables win over plain
+// ones, and the values aren't expanded.
+var environmentSettings = map[string]interface{}{
+	"program":               environmentProgram,
+	"environment":           map[string]interface{}{"NAME": "plain", "LITERAL": "it's $HOME *"},
+	"environment_sensitive": map[string]interface{}{"NAME": "sensitive", "TOKEN": "token 1234"},
+	"on_create":             true,
+}
+
+func TestResourceCamcScriptPackageLocalEnvironment(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the provider's own environment is passed on too
+	t.Setenv("CAMC_TEST_INHERITED", "inherited")
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig("camc_scriptpackage", withSettings(environmentSettings, map[string]interface{}{"working_dir": dir})),
+		Check:  checkEnvironment("camc_scriptpackage.test", dir, "inherited"),
+	})
+}
+
+func TestResourceCamcScriptPackageRemoteEnvironment(t *testing.T) {
+	provider := remoteProviderConfig(t)
+	// runs the environmentProgram, which must see the same however it's
+	// passed the environment, and returns its command line
+	run := func(t *testing.T, server *sshTestServer, settings map[string]interface{}, pwd string) string {
+		t.Helper()
+		runSteps(t, resource.TestStep{
+			Config: provider + resourceConfig("camc_scriptpackage", remoteConfig(server, withSettings(environmentSettings, settings))),
+			Check:  checkEnvironment("camc_scriptpackage.test", pwd, ""),
+		})
+		commands := server.Commands()
+		if len(commands) != 1 {
+			t.Fatalf("remote host ran %q", commands)
+		}
+		return commands[0]
+	}
+	const program = `sh -c 'printf '\''{"name":"%s","literal":"%s","token":"%s","inherited":"%s","pwd":"%s","arg":"%s"}'\'' "$NAME" "$LITERAL" "$TOKEN" "$CAMC_TEST_INHERITED" "$(pwd -P)" "$1"' sh 'two words'`
+	const exports = `export LITERAL='it'\''s $HOME *' NAME=sensitive TOKEN='token 1234';`
+	t.Setenv("CAMC_TEST_INHERITED", "inherited")
+
+	// the variables are set with setenv, in the order of their names
+	t.Run("setenv", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		if command := run(t, server, nil, server.home); command != program {
+			t.Errorf("remote host ran %q", command)
+		}
+		if env := server.Env(); strings.Join(env, " ") != "LITERAL NAME TOKEN" {
+			t.Errorf("remote host was sent the environment %q", env)
+		}
+	})
+
+	// a host that rejects them has them exported by the command instead
+	t.Run("export", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		server.RejectEnv()
+		if command := run(t, server, nil, server.home); command != exports+" "+program {
+			t.Errorf("remote host ran %q", command)
+		}
+		if env := server.Env(); len(env) != 0 {
+			t.Errorf("remote host accepted the environment %q", env)
+		}
+	})
+
+	// the command changes to working_dir, after the exports
+	t.Run("working_dir", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		server.RejectEnv()
+		dir := filepath.Join(server.home, "work dir")
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		dir, _ = filepath.EvalSymlinks(dir)
+		if command := run(t, server, map[string]interface{}{"working_dir": dir}, dir); command != exports+" cd '"+dir+"' || exit 1; "+program {
+			t.Errorf("remote host ran %q", command)
+		}
+	})
+
+	// the program isn't run when working_dir is missing
+	t.Run("missing working_dir", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		runSteps(t, resource.TestStep{
+			Config: provider + resourceConfig("camc_scriptpackage", remoteConfig(server, map[string]interface{}{
+				"program":     []interface{}{"touch", filepath.Join(server.home, "ran")},
+				"working_dir": filepath.Join(server.home, "missing"),
+				"on_create":   true,
+			})),
+			ExpectError: errorMatching("Failed to execute \"touch\""),
+		})
+		if _, err := os.Stat(filepath.Join(server.home, "ran")); err == nil {
+			t.Error("the program ran outside working_dir")
+		}
+	})
+}
+
+// Each element of a program is passed as is, locally as an argument and
+// remotely quoted for the shell of the host. The quoted words are the command
+// lines the remote host is sent.
+func TestResourceCamcScriptPackageQuoting(t *testing.T) {
+	cases := []struct {
+		word   string
+		quoted string
+	}{
+		{"plain", "plain"},
+		{"--dir=/tmp/a,b:c@d%e+f", "'--dir=/tmp/a,b:c@d%e+f'"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{"''", `''\'''\'''`},
+		{"$HOME", "'$HOME'"},
+		{"`id`; exit 1", "'`id`; exit 1'"},
+		{"", "''"},
+		{"*.sh", "'*.sh'"},
+		{"[a-z]?", "'[a-z]?'"},
+	}
+	server, dir := newGlobHosts(t)
+	config := func(settings map[string]interface{}) string {
+		var config string
+		for i, c := range cases {
+			config += namedResourceConfig("camc_scriptpackage", fmt.Sprintf("case_%d", i), withSettings(map[string]interface{}{
+				"program":   []interface{}{"printf", "%s", c.word},
+				"on_create": true,
+			}, settings))
+		}
+		return config
+	}
+	var checks []resource.TestCheckFunc
+	var commands []string
+	for i, c := range cases {
+		checks = append(checks, resource.TestCheckResourceAttr(fmt.Sprintf("camc_scriptpackage.case_%d", i), "result.stdout", c.word))
+		commands = append(commands, "printf %s "+c.quoted)
+	}
+
+	runSteps(t, resource.TestStep{
+		Config: config(map[string]interface{}{"working_dir": dir}),
+		Check:  resource.ComposeTestCheckFunc(checks...),
+	})
+	runSteps(t, resource.TestStep{
+		Config: remoteProviderConfig(t) + config(remoteConfig(server, map[string]interface{}{})),
+		Check:  resource.ComposeTestCheckFunc(append(checks, checkCommands(server, commands))...),
+	})
+}
+
+// With raw_command the elements are joined with spaces and run by the shell,
+// which expands them, locally and remotely alike
+func TestResourceCamcScriptPackageRawCommand(t *testing.T) {
+	cases := []struct {
+		program []string
+		stdout  string
+	}{
+		{[]string{"echo", "$NAME"}, "world"},
+		{[]string{"echo", "'$NAME'"}, "$NAME"},
+		{[]string{"printf %s", `'it'\''s'`}, "it's"},
+		{[]string{"echo", "", "empty"}, "empty"},
+		{[]string{"echo", "*.sh"}, "glob.sh"},
+		{[]string{"echo a", "&&", "echo b"}, "a\nb"},
+		{[]string{"echo $(printf", "%s", "two)", "words"}, "two words"},
+	}
+	server, dir := newGlobHosts(t)
+	config := func(settings map[string]interface{}) string {
+		var config string
+		for i, c := range cases {
+			config += namedResourceConfig("camc_scriptpackage", fmt.Sprintf("case_%d", i), withSettings(map[string]interface{}{
+				"program":     c.program,
+				"raw_command": true,
+				"environment": map[string]interface{}{"NAME": "world"},
+				"on_create":   true,
+			}, settings))
+		}
+		return config
+	}
+	var checks []resource.TestCheckFunc
+	var commands []string
+	for i, c := range cases {
+		checks = append(checks, resource.TestCheckResourceAttr(fmt.Sprintf("camc_scriptpackage.case_%d", i), "result.stdout", c.stdout))
+		commands = append(commands, strings.Join(c.program, " "))
+	}
+
+	runSteps(t, resource.TestStep{
+		Config: config(map[string]interface{}{"working_dir": dir}),
+		Check:  resource.ComposeTestCheckFunc(checks...),
+	})
+	runSteps(t, resource.TestStep{
+		Config: remoteProviderConfig(t) + config(remoteConfig(server, map[string]interface{}{})),
+		Check:  resource.ComposeTestCheckFunc(append(checks, checkCommands(server, commands))...),
+	})
+}
+
+// Returns an ssh test server and a local directory, each holding a file
+// glob.sh for patterns to match
+func newGlobHosts(t *testing.T) (*sshTestServer, string) {
+	server := newSSHTestServer(t)
+	dir := t.TempDir()
+	for _, home := range []string{dir, server.home} {
+		if err := os.WriteFile(filepath.Join(home, "glob.sh"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return server, dir
+}
+
+// Returns a check that server ran the commands given, in any order since
+// resources are created in parallel
+func checkCommands(server *sshTestServer, want []string) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		got := server.Commands()
+		want = append([]string(nil), want...)
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			return fmt.Errorf("remote host ran %q, expected %q", got, want)
+		}
+		return nil
+	}
+}
+
+func TestResourceCamcScriptPackageRemoteKey(t *testing.T) {
+	server := newSSHTestServer(t)
+	runSteps(t, resource.TestStep{
+		Config: remoteProviderConfig(t) + resourceConfig("camc_scriptpackage", remoteConfig(server, map[string]interface{}{
+			"program":    []interface{}{"sh", "-c", "echo $HOME"},
+			"remote_key": server.clientKey,
+			"on_create":  true,
+		})),
+		Check: resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.stdout", server.home),
+	})
+}
+
+func TestResourceCamcScriptPackageProviderKey(t *testing.T) {
+	server := newSSHTestServer(t)
+	bastion := newSSHTestServer(t)
+	t.Setenv("CAMC_SSH_PRIVATE_KEY", server.clientKey)
+	provider := remoteProviderConfig(t)
+	config := map[string]interface{}{
+		"program":     []interface{}{"sh", "-c", "echo $HOME"},
+		"remote_host": server.name,
+		"remote_user": testSSHUser,
+		"on_create":   true,
+	}
+
+	// hosts without a password or key of their own use the provider's
+	runSteps(t, resource.TestStep{
+		Config: provider + namedResourceConfig("camc_scriptpackage", "direct", config) +
+			namedResourceConfig("camc_scriptpackage", "bastion", withSettings(config, map[string]interface{}{
+				"bastion_host":     bastion.host,
+				"bastion_port":     bastion.port,
+				"bastion_user":     testSSHUser,
+				"bastion_password": testSSHPassword,
+			})),
+		Check: resource.ComposeTestCheckFunc(
+			resource.TestCheckResourceAttr("camc_scriptpackage.direct", "result.stdout", server.home),
+			resource.TestCheckResourceAttr("camc_scriptpackage.bastion", "result.stdout", server.home),
+			func(*terraform.State) error {
+				if tunnels := bastion.Tunnels(); len(tunnels) != 1 {
+					return fmt.Errorf("bastion forwarded to %q", tunnels)
+				}
+				return nil
+			},
+		),
+	})
+
+	// without it, a host needs a password or key
+	t.Setenv("CAMC_SSH_PRIVATE_KEY", "")
+	runSteps(t, resource.TestStep{
+		Config:      provider + resourceConfig("camc_scriptpackage", config),
+		ExpectError: errorMatching("One of remote_password or remote_key is required"),
+	})
+
+	t.Setenv("CAMC_SSH_PRIVATE_KEY", "not-a-key")
+	runSteps(t, resource.TestStep{
+		Config:      provider + resourceConfig("camc_scriptpackage", config),
+		PlanOnly:    true,
+		ExpectError: errorMatching("Error decoding private key"),
+	})
+}
+
+func TestResourceCamcScriptPackageBastion(t *testing.T) {
+	server := newSSHTestServer(t)
+	bastion := newSSHTestServer(t)
+	// the remote host is reached by its name through the bastion host alone
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig("camc_scriptpackage", remoteConfig(server, map[string]interface{}{
+			"program":             []interface{}{"sh", "-c", `printf '{"via":"bastion"}'`},
+			"bastion_host":        bastion.host,
+			"bastion_port":        bastion.port,
+			"bastion_user":        testSSHUser,
+			"bastion_private_key": bastion.clientKey,
+			"on_create":           true,
+		})),
+		Check: resource.ComposeTestCheckFunc(
+			resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.via", "bastion"),
+			func(*terraform.State) error {
+				if tunnels := bastion.Tunnels(); len(tunnels) != 1 || tunnels[0] != server.name+":22" {
+					return fmt.Errorf("bastion forwarded to %q", tunnels)
+				}
+				if commands := bastion.Commands(); len(commands) != 0 {
+					return fmt.Errorf("bastion ran %q", commands)
+				}
+				if commands := server.Commands(); len(commands) != 1 {
+					return fmt.Errorf("remote host ran %q", commands)
+				}
+				return nil
+			},
+		),
+	})
+}
+
+func TestResourceCamcScriptPackageProxy(t *testing.T) {
+	server := newSSHTestServer(t)
+	socks := newSOCKS5ProxyStub(t, "proxy-user", "proxy-password-1234")
+	config := remoteConfig(server, map[string]interface{}{
+		"program":   []interface{}{"sh", "-c", `printf '{"via":"proxy"}'`},
+		"on_create": true,
+	})
+
+	runSteps(t, resource.TestStep{
+		Config: providerConfig(map[string]interface{}{
+			"ssh_proxy":      socks.URL,
+			"proxy_username": "proxy-user",
+			"proxy_password": "proxy-password-1234",
+		}) + resourceConfig("camc_scriptpackage", config),
+		Check: resource.ComposeTestCheckFunc(
+			resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.via", "proxy"),
+			func(*terraform.State) error {
+				if targets := socks.Targets(); len(targets) != 1 || targets[0] != server.name+":22" {
+					return fmt.Errorf("the SOCKS5 proxy was asked for %q", targets)
+				}
+				return nil
+			},
+		),
+	})
+
+	// hosts in no_proxy are connected to directly, and only the proxy knows
+	// the name of the stand-in
+	runSteps(t, resource.TestStep{
+		Config: providerConfig(map[string]interface{}{
+			"ssh_proxy":      socks.URL,
+			"no_proxy":       server.name,
+			"proxy_username": "proxy-user",
+			"proxy_password": "proxy-password-1234",
+		}) + resourceConfig("camc_scriptpackage", config),
+		ExpectError: errorMatching("Error connecting to remote host"),
+	})
+	if targets := socks.Targets(); len(targets) != 0 {
+		t.Errorf("the SOCKS5 proxy was asked for %q", targets)
+	}
+
+	// only the bastion host is connected to through an HTTP CONNECT proxy
+	bastion := newSSHTestServer(t)
+	connect := newHTTPProxyStub(t, "", "", nil)
+	withBastion := withSettings(config, map[string]interface{}{
+		"bastion_host":        bastion.host,
+		"bastion_port":        bastion.port,
+		"bastion_user":        testSSHUser,
+		"bastion_private_key": bastion.clientKey,
+	})
+	runSteps(t, resource.TestStep{
+		Config: providerConfig(map[string]interface{}{"ssh_proxy": connect.URL}) +
+			resourceConfig("camc_scriptpackage", withBastion),
+		Check: func(*terraform.State) error {
+			if targets := connect.Targets(); len(targets) != 1 || targets[0] != bastion.addr() {
+				return fmt.Errorf("the CONNECT proxy was asked for %q", targets)
+			}
+			if tunnels := bastion.Tunnels(); len(tunnels) != 1 || tunnels[0] != server.name+":22" {
+				return fmt.Errorf("bastion forwarded to %q", tunnels)
+			}
+			return nil
+		},
+	})
+
+	// the proxy refusing the connection fails the apply
+	socks = newSOCKS5ProxyStub(t, "proxy-user", "proxy-password-1234")
+	runSteps(t, resource.TestStep{
+		Config:      providerConfig(map[string]interface{}{"ssh_proxy": socks.URL}) + resourceConfig("camc_scriptpackage", config),
+		ExpectError: errorMatching("Error connecting to remote host"),
+	})
+}
+
+// Returns a check that destination ~/name is the file name in the home
+// directory of the server, not under a directory named ~
+func checkInHome(server *sshTestServer, name string) resource.TestCheckFunc {
+	return func(*terraform.State) error {
+		if _, err := os.Stat(filepath.Join(server.home, name)); err != nil {
+			return fmt.Errorf("source wasn't copied to the remote host: %s", err)
+		}
+		if _, err := os.Stat(filepath.Join(server.home, "~")); err == nil {
+			return fmt.Errorf("~ of the destination was taken literally")
+		}
+		return nil
+	}
+}
+
+func TestResourceCamcScriptPackageTransfer(t *testing.T) {
+	server := newSSHTestServer(t)
+	source := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(source, []byte(`printf '{"from":"transfer"}'`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, resource.TestStep{
+		Config: remoteProviderConfig(t) + resourceConfig("camc_scriptpackage", remoteConfig(server, map[string]interface{}{
+			"program":     []interface{}{"sh", "script.sh"},
+			"source":      source,
+			"destination": "~/script.sh",
+			"on_create":   true,
+		})),
+		Check: resource.ComposeTestCheckFunc(
+			resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.from", "transfer"),
+			checkInHome(server, "script.sh"),
+		),
+	})
+}
+
+func TestResourceCamcScriptPackageRemoteDownload(t *testing.T) {
+	server := newSSHTestServer(t)
+	ts := newScriptServer(t, `printf '{"from":"download"}'`)
+	runSteps(t, resource.TestStep{
+		Config: remoteProviderConfig(t) + resourceConfig("camc_scriptpackage", remoteConfig(server, map[string]interface{}{
+			"program":     []interface{}{"sh", "downloaded.sh"},
+			"source":      ts.URL + "/script.sh",
+			"destination": "~/downloaded.sh",
+			"on_create":   true,
+		})),
+		Check: resource.ComposeTestCheckFunc(
+			resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.from", "download"),
+			checkInHome(server, "downloaded.sh"),
+		),
+	})
+}
+
+func TestResourceCamcScriptPackageBecome(t *testing.T) {
+	provider := remoteProviderConfig(t)
+	const password = "become-password-1234"
+	const query = `{"answer":"42"}`
+	config := func(server *sshTestServer, settings map[string]interface{}) string {
+		return provider + resourceConfig("camc_scriptpackage", remoteConfig(server, withSettings(map[string]interface{}{
+			"program":   []interface{}{"cat"},
+			"query":     map[string]interface{}{"answer": "42"},
+			"become":    true,
+			"on_create": true,
+		}, settings)))
+	}
+	// checks the commands the server ran and what each was sent on stdin
+	checkRun := func(server *sshTestServer, commands []string, stdin []string) resource.TestCheckFunc {
+		return func(*terraform.State) error {
+			if got := server.Commands(); strings.Join(got, "\n") != strings.Join(commands, "\n") {
+				return fmt.Errorf("remote host ran %q, expected %q", got, commands)
+			}
+			if got := server.Stdin(); strings.Join(got, "\x00") != strings.Join(stdin, "\x00") {
+				return fmt.Errorf("remote host was sent %q, expected %q", got, stdin)
+			}
+			return nil
+		}
+	}
+
+	// sudo that doesn't ask for the password isn't sent it, so the program
+	// reads the query alone
+	t.Run("NOPASSWD", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		server.Sudo(t, "")
+		runSteps(t, resource.TestStep{
+			Config: config(server, map[string]interface{}{"become_password": password}),
+			Check: resource.ComposeTestCheckFunc(
+				resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.answer", "42"),
+				checkRun(server, []string{"LC_ALL=C sudo -n -u root -- true", "sudo -n -u root -- /bin/sh -c cat"}, []string{"", query}),
+			),
+		})
+	})
+
+	t.Run("password", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		server.Sudo(t, password)
+		runSteps(t, resource.TestStep{
+			Config: config(server, map[string]interface{}{"become_password": password}),
+			Check: resource.ComposeTestCheckFunc(
+				resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.answer", "42"),
+				checkRun(server, []string{"LC_ALL=C sudo -n -u root -- true", "sudo -k -S -p '' -u root -- /bin/sh -c cat"}, []string{"", password + "\n" + query}),
+			),
+		})
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		server.Sudo(t, "another-password-1234")
+		tp := runSteps(t, resource.TestStep{
+			Config:      config(server, map[string]interface{}{"become_password": password}),
+			ExpectError: errorMatching("sudo: incorrect password"),
+		})
+		tp.expectRedacted(t, password)
+	})
+
+	// other failures of sudo aren't taken for a password prompt
+	t.Run("unknown become_user", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		server.Sudo(t, password)
+		runSteps(t, resource.TestStep{
+			Config: config(server, map[string]interface{}{
+				"become_user":     "missing",
+				"become_password": password,
+			}),
+			ExpectError: errorMatching("sudo can't run programs as missing: sudo: unknown user missing"),
+		})
+		if err := checkRun(server, []string{"LC_ALL=C sudo -n -u missing -- true"}, []string{""})(nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// without a password sudo mustn't wait on a prompt
+	t.Run("no password", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		server.Sudo(t, password)
+		runSteps(t, resource.TestStep{
+			Config:      config(server, nil),
+			ExpectError: errorMatching("sudo: a password is required"),
+		})
+		if err := checkRun(server, []string{"sudo -n -u root -- /bin/sh -c cat"}, []string{query})(nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// sudo resets the environment, so it's exported by the command it runs,
+	// after which it changes to working_dir
+	t.Run("become_user", func(t *testing.T) {
+		server := newSSHTestServer(t)
+		server.Sudo(t, "")
+		dir, _ := filepath.EvalSymlinks(server.home)
+		runSteps(t, resource.TestStep{
+			Config: config(server, map[string]interface{}{
+				"program":     []interface{}{"sh", "-c", `printf '{"user":"%s","name":"%s","pwd":"%s"}' "$USER" "$NAME" "$(pwd -P)"`},
+				"become_user": "deploy",
+				"environment": map[string]interface{}{"NAME": "world"},
+				"working_dir": dir,
+			}),
+			Check: resource.ComposeTestCheckFunc(
+				resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.user", "deploy"),
+				resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.name", "world"),
+				resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.pwd", dir),
+				func(*terraform.State) error {
+					commands := server.Commands()
+					if len(commands) != 1 || !strings.HasPrefix(commands[0], "sudo -n -u deploy -- /bin/sh -c 'export NAME=world; cd "+dir+" || exit 1; sh -c ") {
+						return fmt.Errorf("remote host ran %q", commands)
+					}
+					if env := server.Env(); len(env) != 0 {
+						return fmt.Errorf("remote host was sent the environment %q", env)
+					}
+					return nil
+				},
+			),
+		})
+	})
+}
+
+// A program that writes to stdout and stderr in turn, a secret among them,
+// and ends without a newline
+const interleavingProgram = `echo out 1; sleep 0.2; echo err 1 >&2; sleep 0.2; echo out $TOKEN; sleep 0.2; echo err $TOKEN >&2; sleep 0.2; printf partial`
+
+// Returns the lines of the transcript at path, with each timestamp replaced
+// by TIME once it's checked
+func readTranscript(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	for i, line := range lines {
+		header := strings.HasPrefix(line, "=== ")
+		rest := strings.TrimPrefix(line, "=== ")
+		timestamp, rest, _ := strings.Cut(rest, " ")
+		if _, err := time.Parse(time.RFC3339, timestamp); err != nil {
+			return nil, fmt.Errorf("line %d of the transcript has no timestamp: %q", i+1, line)
+		}
+		if header {
+			lines[i] = "=== TIME " + rest
+		} else {
+			lines[i] = "TIME " + rest
+		}
+	}
+	return lines, nil
+}
+
+// The program's output is appended to log_file as it arrives, a line at a
+// time in the order it was written, with the secrets masked
+func TestResourceCamcScriptPackageLogFile(t *testing.T) {
+	server := newSSHTestServer(t)
+	provider := remoteProviderConfig(t)
+	run := []string{
+		"TIME [stdout] out 1",
+		"TIME [stderr] err 1",
+		"TIME [stdout] out ******",
+		"TIME [stderr] err ******",
+		"TIME [stdout] partial",
+	}
+	for _, c := range []struct {
+		name     string
+		settings map[string]interface{}
+		label    string
+	}{
+		{"local", map[string]interface{}{}, "local sh"},
+		{"remote", remoteConfig(server, map[string]interface{}{}), server.name + " sh"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "transcript.log")
+			// each run is appended under a header of its own
+			want := append([]string{"=== TIME " + c.label + " ==="}, run...)
+			want = append(want, want...)
+			runTestCase(t, resource.TestCase{
+				Steps: []resource.TestStep{{
+					Config: provider + resourceConfig("camc_scriptpackage", withSettings(map[string]interface{}{
+						"program":               []interface{}{"sh", "-c", interleavingProgram},
+						"environment_sensitive": map[string]interface{}{"TOKEN": "secret-token-1234"},
+						"log_file":              logFile,
+						"on_create":             true,
+						"on_delete":             true,
+					}, c.settings)),
+					// the captured output isn't masked
+					Check: resource.TestCheckResourceAttr("camc_scriptpackage.test", "result.stdout", "out 1\nout secret-token-1234\npartial"),
+				}},
+				CheckDestroy: func(*terraform.State) error {
+					got, err := readTranscript(logFile)
+					if err != nil {
+						return err
+					}
+					if strings.Join(got, "\n") != strings.Join(want, "\n") {
+						return fmt.Errorf("log_file holds %q, expected %q", got, want)
+					}
+					return nil
+				},
+			})
+		})
+	}
+
+	// a log_file that can't be opened fails the run
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig("camc_scriptpackage", map[string]interface{}{
+			"program":   []interface{}{"true"},
+			"log_file":  filepath.Join(t.TempDir(), "missing", "transcript.log"),
+			"on_create": true,
+		}),
+		ExpectError: errorMatching("Error opening log_file"),
+	})
+}
+
+// Each line of the program's output is logged as it arrives, with the
+// resource it's the program of
+func TestResourceCamcScriptPackageLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "terraform.log")
+	t.Setenv("TF_LOG", "INFO")
+	t.Setenv("TF_LOG_PATH", logPath)
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig("camc_scriptpackage", map[string]interface{}{
+			"program":   []interface{}{"sh", "-c", `echo step 1; printf '{"answer":"42"}'`},
+			"on_create": true,
+		}),
+		Check: func(s *terraform.State) error {
+			log, err := os.ReadFile(logPath)
+			if err != nil {
+				return err
+			}
+			id := stateAttr(s, "camc_scriptpackage.test", "id")
+			for _, line := range strings.Split(string(log), "\n") {
+				if strings.Contains(line, "camc.exec: step 1:") {
+					for _, field := range []string{"resource_id=" + id, "tf_resource_type=camc_scriptpackage", "host=local", "stream=stdout"} {
+						if !strings.Contains(line, field) {
+							return fmt.Errorf("%q doesn't have %s", line, field)
+						}
+					}
+					return nil
+				}
+			}
+			return fmt.Errorf("the output wasn't logged")
+		},
+	})
+}
+
+// With request_pty the program's stdout and stderr are one stream, of which
+// the result is the JSON object if there is one. The query is passed whole,
+// however long and whatever it holds.
+func TestResourceCamcScriptPackagePty(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the ssh test server has no pseudo terminals on " + runtime.GOOS)
+	}
+	server := newSSHTestServer(t)
+	server.Sudo(t, "become-password-1234")
+	long := strings.Repeat("0123456789", 1000) + "\x7f\x15\x03\x04"
+	cases := []struct {
+		name     string
+		settings map[string]interface{}
+		result   map[string]string
+	}{
+		{"result", map[string]interface{}{"program": []interface{}{"sh", "-c", `printf '{"answer":"42"}'`}}, map[string]string{"answer": "42"}},
+		{"crlf", map[string]interface{}{"program": []interface{}{"sh", "-c", `printf '{\r\n  "answer": "42"\r\n}\r\n'`}}, map[string]string{"answer": "42"}},
+		{"progress", map[string]interface{}{"program": []interface{}{"sh", "-c", `echo step 1; echo step 2; echo '{"answer":"42"}'`}}, map[string]string{"answer": "42"}},
+		{"stderr", map[string]interface{}{"program": []interface{}{"sh", "-c", `echo warning >&2; echo '{"answer":"42"}'; echo done >&2`}}, map[string]string{"answer": "42"}},
+		{"no_json", map[string]interface{}{"program": []interface{}{"sh", "-c", `echo hello; echo world >&2`}}, map[string]string{"stdout": "hello\nworld"}},
+		{"query", map[string]interface{}{"program": []interface{}{"cat"}, "query": map[string]interface{}{"long": long}}, map[string]string{"long": long}},
+		{"raw_command", map[string]interface{}{"program": []interface{}{"true", "&&", "cat"}, "raw_command": true, "query": map[string]interface{}{"answer": "42"}}, map[string]string{"answer": "42"}},
+		{"become", map[string]interface{}{"program": []interface{}{"cat"}, "become": true, "become_password": "become-password-1234", "query": map[string]interface{}{"answer": "42"}}, map[string]string{"answer": "42"}},
+		{"terminal", map[string]interface{}{
+			"program":    []interface{}{"sh", "-c", `printf '{"size":"%s","term":"%s","tty":"%s"}' "$(stty size < /dev/tty)" "$TERM" "$(test -t 2 && echo yes)"`},
+			"pty_term":   "vt100",
+			"pty_width":  120,
+			"pty_height": 40,
+		}, map[string]string{"size": "40 120", "term": "vt100", "tty": "yes"}},
+	}
+	var config string
+	var checks []resource.TestCheckFunc
+	for _, c := range cases {
+		config += namedResourceConfig("camc_scriptpackage", c.name, remoteConfig(server, withSettings(map[string]interface{}{
+			"request_pty": true,
+			"on_create":   true,
+		}, c.settings)))
+		address := "camc_scriptpackage." + c.name
+		checks = append(checks, resource.TestCheckResourceAttr(address, "result.%", strconv.Itoa(len(c.result))))
+		for k, v := range c.result {
+			checks = append(checks, resource.TestCheckResourceAttr(address, "result."+k, v))
+		}
+	}
+	provider := remoteProviderConfig(t)
+
+	runSteps(t, resource.TestStep{
+		Config: provider + config,
+		Check: resource.ComposeTestCheckFunc(append(checks, func(*terraform.State) error {
+			// sudo reads the password ahead of the query
+			commands, stdin := server.Commands(), server.Stdin()
+			for i, command := range commands {
+				if strings.HasPrefix(command, "sudo -k -S") {
+					if stdin[i] != "become-password-1234\n"+`{"answer":"42"}` {
+						return fmt.Errorf("%q was sent %q", command, stdin[i])
+					}
+					return nil
+				}
+			}
+			return fmt.Errorf("remote host ran %q", commands)
+		})...),
+	})
+
+	// the error is in the output, with a pty there's no stderr
+	runSteps(t, resource.TestStep{
+		Config: provider + resourceConfig("camc_scriptpackage", remoteConfig(server, map[string]interface{}{
+			"program":     []interface{}{"sh", "-c", "echo step 1; echo failed >&2; exit 3"},
+			"request_pty": true,
+			"on_create":   true,
+		})),
+		ExpectError: errorMatching("step 1 failed"),
+	})
+}
+
+func TestResourceCamcScriptPackageErrors(t *testing.T) {
+	server := newSSHTestServer(t)
+	provider := remoteProviderConfig(t)
+
+	// what the program wrote to stderr is reported, with the secrets masked
+	tp := runSteps(t,
+		resource.TestStep{
+			Config: resourceConfig("camc_scriptpackage", map[string]interface{}{
+				"program":   []interface{}{"sh", "-c", "echo token $TOKEN rejected >&2; exit 3"},
+				"on_create": true,
+				"environment_sensitive": map[string]interface{}{
+					"TOKEN": "secret-token-1234",
+				},
+			}),
+			ExpectError: errorMatching("token ****** rejected"),
+		},
+		resource.TestStep{
+			Config: provider + resourceConfig("camc_scriptpackage", remoteConfig(server, map[string]interface{}{
+				"program":   []interface{}{"sh", "-c", "echo denied for $PASSWORD >&2; exit 4"},
+				"on_create": true,
+				"environment_sensitive": map[string]interface{}{
+					"PASSWORD": testSSHPassword,
+				},
+			})),
+			ExpectError: errorMatching("denied for ******"),
+		},
+		resource.TestStep{
+			Config: provider + resourceConfig("camc_scriptpackage", withSettings(remoteConfig(server, map[string]interface{}{
+				"program":   []interface{}{"true"},
+				"on_create": true,
+			}), map[string]interface{}{"remote_password": "wrong-password"})),
+			ExpectError: errorMatching("Error connecting to remote host"),
+		},
+		// bad combinations fail the plan
+		resource.TestStep{
+			Config: resourceConfig("camc_scriptpackage", map[string]interface{}{
+				"program":   []interface{}{"true"},
+				"source":    "http://localhost/script.sh",
+				"on_create": true,
+			}),
+			ExpectError: errorMatching("source was specified, but not destination"),
+		},
+		resource.TestStep{
+			Config: resourceConfig("camc_scriptpackage", map[string]interface{}{
+				"program":     []interface{}{"true"},
+				"remote_host": server.name,
+				"on_create":   true,
+			}),
+			ExpectError: errorMatching("remote_user is required when specifying remote_host"),
+		},
+		resource.TestStep{
+			Config: resourceConfig("camc_scriptpackage", map[string]interface{}{
+				"program":    []interface{}{"true"},
+				"remote_key": "not a key",
+				"on_create":  true,
+			}),
+			ExpectError: errorMatching("Error decoding private key"),
+		},
+		resource.TestStep{
+			Config: resourceConfig("camc_scriptpackage", map[string]interface{}{
+				"program":     []interface{}{"true"},
+				"request_pty": true,
+				"pty_width":   0,
+				"on_create":   true,
+			}),
+			ExpectError: errorMatching("expected pty_width to be at least (1), got 0"),
+		},
+	)
+	tp.expectRedacted(t, "secret-token-1234")
+	tp.expectRedacted(t, testSSHPassword)
+
+	if commands := server.Commands(); len(commands) != 1 {
+		t.Errorf("remote host ran %q", commands)
+	}
+}
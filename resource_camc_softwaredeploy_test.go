@@ -0,0 +1,14 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package main
+
+import (
+	"testing"
+)
+
+func TestResourceCamcSoftwaredeploy(t *testing.T) {
+	// software deployments are left in place when the resource is destroyed
+	testPatternManagerResource(t, "camc_softwaredeploy", false)
+}
@@ -0,0 +1,109 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// camc isn't served by the provider, the test provider adds it
+
+func TestResourceCAMC(t *testing.T) {
+	stub := newPatternManagerStub(t)
+	runTestCase(t, resource.TestCase{
+		Steps: []resource.TestStep{{
+			Config: resourceConfig("camc", map[string]interface{}{
+				"name":       "camc-resource",
+				"url":        stub.URL,
+				"delete_url": stub.URL,
+				"method":     "POST",
+				"payload":    `{"name":"first"}`,
+			}),
+		}},
+		CheckDestroy: func(*terraform.State) error {
+			requests := stub.Requests()
+			if len(requests) != 2 || requests[0].Body != `{"name":"first"}` {
+				return fmt.Errorf("pattern manager was sent %+v", requests)
+			}
+			return nil
+		},
+	})
+}
+
+func TestResourceCAMCRequestErrors(t *testing.T) {
+	files := newTestTLSFiles(t)
+	stub := newPatternManagerStub(t)
+	testRequestErrors(t, "camc", stub, map[string]interface{}{
+		"name":    "camc-resource",
+		"url":     stub.URL,
+		"method":  "POST",
+		"payload": `{"name":"first"}`,
+	}, append([]requestErrorCase{
+		{"unreadable cert_file", map[string]interface{}{"cert_file": files.missing, "key_file": files.keyFile, "ca_file": files.certFile}, "Error reading cert_file"},
+		{"unreadable ca_file", map[string]interface{}{"cert_file": files.certFile, "key_file": files.keyFile, "ca_file": files.missing}, "Error reading ca_file"},
+		{"ca_file without PEM", map[string]interface{}{"cert_file": files.certFile, "key_file": files.keyFile, "ca_file": files.notPEM}, "ca_file contains no PEM certificates"},
+		{"invalid url", map[string]interface{}{"url": "http://bad host/"}, "Invalid request to http://bad host/"},
+		{"invalid method", map[string]interface{}{"method": "BAD METHOD"}, "invalid method"},
+		{"invalid payload", map[string]interface{}{"payload": "not json"}, "Value is not valid json"},
+	}, transportErrorCases(t, "url")...))
+}
+
+// ca_file is trusted on its own, without a client certificate
+func TestResourceCAMCCAFile(t *testing.T) {
+	stub := newPatternManagerStubTLS(t, nil)
+	caFile := writeCAFile(t, stub.CertificatePEM())
+	config := map[string]interface{}{
+		"name":   "camc-resource",
+		"url":    stub.URL,
+		"method": "POST",
+	}
+	runSteps(t, resource.TestStep{
+		Config:      resourceConfig("camc", config),
+		ExpectError: errorMatching("certificate signed by unknown authority"),
+	})
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig("camc", withSettings(config, map[string]interface{}{"ca_file": caFile})),
+		Check:  checkRequests(stub, "POST /"),
+	})
+}
+
+func TestResourceCAMCStatusCodes(t *testing.T) {
+	stub := newPatternManagerStub(t)
+	config := map[string]interface{}{
+		"name":       "camc-resource",
+		"url":        stub.URL + "/items",
+		"delete_url": stub.URL + "/items/1",
+		"method":     "POST",
+	}
+
+	runSteps(t,
+		resource.TestStep{
+			PreConfig: func() {
+				stub.Queue(202, http.Header{"Location": {"/operations/1"}, "Retry-After": {"0"}}, "")
+				stub.Queue(201, nil, `{"id":"1"}`)
+			},
+			Config: resourceConfig("camc", config),
+			Check:  checkRequests(stub, "POST /items", "GET /operations/1"),
+		},
+		// a delete of an item that's already gone succeeds
+		resource.TestStep{
+			PreConfig: func() { stub.Queue(410, nil, "") },
+			Config:    resourceConfig("camc", config),
+			Destroy:   true,
+		},
+	)
+
+	runSteps(t, resource.TestStep{
+		Config: resourceConfig("camc", withSettings(config, map[string]interface{}{
+			"expected_status_codes": block{"create": []interface{}{201}},
+		})),
+		ExpectError: errorMatching("POST /items returned 200 OK"),
+	})
+}
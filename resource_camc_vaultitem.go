@@ -5,16 +5,30 @@
 package main
 
 import (
+	"context"
+	"time"
+
 	"github.com/IBM-CAMHub-Open/terraform-provider-camc/common"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 func resourceCamcVaultitem() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceCamcVaultitemCreate,
-		Read:   resourceCamcVaultitemRead,
-		Update: resourceCamcVaultitemUpdate,
-		Delete: resourceCamcVaultitemDelete,
+		CreateContext: resourceCamcVaultitemCreate,
+		ReadContext:   resourceCamcVaultitemRead,
+		UpdateContext: resourceCamcVaultitemUpdate,
+		DeleteContext: resourceCamcVaultitemDelete,
+		CustomizeDiff: common.PatternManagerCustomizeDiff,
+		// warns of username and password sent as before auth_mode
+		ValidateRawResourceConfigFuncs: []schema.ValidateRawResourceConfigFunc{common.ValidateLegacyAuth},
+
+		// the operation as a whole, polling included
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(20 * time.Minute),
+			Delete: schema.DefaultTimeout(20 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": &schema.Schema{
@@ -30,106 +44,266 @@ func resourceCamcVaultitem() *schema.Resource {
 			},
 
 			"data": &schema.Schema{
-				Type:      schema.TypeString,
-				Optional:  true,
-				StateFunc: jsonStateFunc,
-				Default:   "null",
-				ForceNew:  true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "null",
+				ForceNew:         true,
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidateJSON,
+				DiffSuppressFunc: common.SuppressEquivalentData,
+				ConflictsWith:    []string{"data_wo"},
 			},
 
-			"username": &schema.Schema{
-				Type:     schema.TypeString,
+			// data that's never saved, it needs Terraform 1.11 or later.
+			// Change data_wo_version to send a new value. Delete has no data
+			// to send, so its request has no body.
+			"data_wo": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				WriteOnly:        true,
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidateJSON,
+			},
+
+			"data_wo_version": &schema.Schema{
+				Type:     schema.TypeInt,
 				Optional: true,
-				Default:  "",
 				ForceNew: true,
 			},
 
-			"password": &schema.Schema{
+			// save a salted hash of data in state instead of data. Delete
+			// can't send the hash, so its request has no body.
+			"hash_data": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"username": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "",
 				ForceNew: true,
 			},
 
+			"password": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				Default:   "",
+				ForceNew:  true,
+				Sensitive: true,
+			},
+
 			"skip_ssl_verify": &schema.Schema{
 				Type:     schema.TypeBool,
 				Optional: true,
-				Default:  true,
-				ForceNew: true,
+				Default:  false,
 			},
 
 			"cert_file": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "",
-				ForceNew: true,
 			},
 
 			"key_file": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "",
-				ForceNew: true,
 			},
 
 			"ca_file": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "",
-				ForceNew: true,
+			},
+
+			"ca_pem": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateDiagFunc: common.ValidatePEM,
+			},
+
+			"cert_pem": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateDiagFunc: common.ValidatePEM,
+			},
+
+			"key_pem": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidatePEM,
+			},
+
+			"tls_server_name": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"min_tls_version": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "1.2",
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice([]string{"1.0", "1.1", "1.2", "1.3"}, false)),
 			},
 
 			"trace": &schema.Schema{
 				Type:     schema.TypeBool,
 				Optional: true,
 				Default:  false,
-				ForceNew: true,
 			},
 
 			"access_token": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+
+			"auth_mode": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          common.AuthModeBearer,
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(common.AuthModes, false)),
+			},
+
+			"api_key": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+
+			"api_key_header": &schema.Schema{
 				Type:     schema.TypeString,
-				Required: true,
+				Optional: true,
+				Default:  "X-API-Key",
+			},
+
+			"headers": &schema.Schema{
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				ValidateDiagFunc: common.ValidateHeaders,
+			},
+
+			"sensitive_headers": &schema.Schema{
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Sensitive:        true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				ValidateDiagFunc: common.ValidateHeaders,
+			},
+
+			"content_type": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          common.DefaultContentType,
+				ValidateDiagFunc: common.ValidateContentType,
+			},
+
+			"expected_status_codes": common.ExpectedStatusCodesSchema(common.OperationCreate, common.OperationDelete),
+
+			// the requests are sent through an ssh connection to the bastion
+			// host when it's set
+			"bastion_host": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"bastion_user": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"bastion_password": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+
+			"bastion_private_key": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidatePrivateKey,
+			},
+
+			"bastion_port": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateDiagFunc: common.ValidatePort,
+			},
+
+			"output_paths": &schema.Schema{
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				ValidateDiagFunc: common.ValidateJSONPaths,
+			},
+
+			"response": &schema.Schema{
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+
+			"status_code": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"outputs": &schema.Schema{
+				Type:      schema.TypeMap,
+				Computed:  true,
+				Elem:      &schema.Schema{Type: schema.TypeString},
+				Sensitive: true,
 			},
 		},
 	}
 }
 
-func resourceCamcVaultitemCreate(d *schema.ResourceData, m interface{}) error {
+func resourceCamcVaultitemCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	//get create camc_endpoint
 
 	camc_endpoint := d.Get("camc_endpoint").(string)
 	if camc_endpoint != "" {
-		_, err := common.MakeRequest(d, m, "POST")
-		if err == nil {
+		resp, diags := common.MakeRequest(ctx, d, m, "POST")
+		if !diags.HasError() {
 			d.SetId(common.GenUUID())
-			return nil
-		} else {
-			return err
+			diags = append(diags, common.SetResponse(d, resp)...)
+			common.SetData(d)
 		}
+		return diags
 	} else {
 		return nil
 	}
 }
 
-func resourceCamcVaultitemRead(d *schema.ResourceData, m interface{}) error {
+func resourceCamcVaultitemRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	return nil
 }
 
-func resourceCamcVaultitemUpdate(d *schema.ResourceData, m interface{}) error {
+func resourceCamcVaultitemUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if d.HasChange("hash_data") {
+		common.SetData(d)
+	}
+	// the outputs are extracted again from the saved response
+	if d.HasChange("output_paths") {
+		return common.SetOutputs(d)
+	}
 	return nil
 }
 
-func resourceCamcVaultitemDelete(d *schema.ResourceData, m interface{}) error {
+func resourceCamcVaultitemDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	//get delete camc_endpoint
 	camc_endpoint := d.Get("camc_endpoint").(string)
 	if camc_endpoint != "" {
-		_, err := common.MakeRequest(d, m, "DELETE")
-		if err == nil {
+		_, diags := common.MakeRequest(ctx, d, m, "DELETE")
+		if !diags.HasError() {
 			d.SetId("")
-			return nil
-		} else {
-			return err
 		}
+		return diags
 	} else {
 		return nil
 	}
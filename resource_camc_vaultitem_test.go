@@ -0,0 +1,172 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestResourceCamcVaultitem(t *testing.T) {
+	testPatternManagerResource(t, "camc_vaultitem", true)
+}
+
+// Returns a check that secret isn't saved in any attribute of the resource
+// at address
+func checkNotInState(address string, secret string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		r, ok := s.RootModule().Resources[address]
+		if !ok {
+			return fmt.Errorf("%s isn't in the state", address)
+		}
+		for k, v := range r.Primary.Attributes {
+			if strings.Contains(v, secret) {
+				return fmt.Errorf("%s of the state reveals a secret: %q", k, v)
+			}
+		}
+		return nil
+	}
+}
+
+func TestResourceCamcVaultitemSensitive(t *testing.T) {
+	r := Provider().ResourcesMap["camc_vaultitem"]
+	for _, k := range []string{"data", "data_wo", "password", "access_token", "api_key", "key_pem", "bastion_password", "bastion_private_key", "response", "outputs"} {
+		if !r.Schema[k].Sensitive {
+			t.Errorf("%s isn't sensitive", k)
+		}
+	}
+	if !r.Schema["data_wo"].WriteOnly {
+		t.Error("data_wo isn't write-only")
+	}
+}
+
+func TestResourceCamcVaultitemHashData(t *testing.T) {
+	stub := newPatternManagerStub(t)
+	address := "camc_vaultitem.test"
+	config := map[string]interface{}{
+		"camc_endpoint": stub.URL,
+		"access_token":  "access-token-1234",
+		"data":          `{"name":"db","password":"vaulted-secret-1234"}`,
+		"hash_data":     true,
+	}
+	replaced := map[string]interface{}{"data": `{"name":"db","password":"vaulted-secret-5678"}`}
+
+	runSteps(t,
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", config),
+			Check: resource.ComposeTestCheckFunc(
+				func(*terraform.State) error {
+					requests := stub.Requests()
+					if len(requests) != 1 || !strings.Contains(requests[0].Body, "vaulted-secret-1234") {
+						return fmt.Errorf("create sent %+v, expected the data", requests)
+					}
+					return nil
+				},
+				resource.TestMatchResourceAttr(address, "data", errorMatching("hmac-sha256:")),
+				checkNotInState(address, "vaulted-secret-1234"),
+			),
+		},
+		// the hash is compared with the document, however it's written
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", withSettings(config, map[string]interface{}{
+				"data": `{ "password": "vaulted-secret-1234", "name": "db" }`,
+			})),
+			Check: checkRequests(stub),
+		},
+		// a new document replaces the item, the old one isn't known to send
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", withSettings(config, replaced)),
+			Check: resource.ComposeTestCheckFunc(
+				func(*terraform.State) error {
+					requests := stub.Requests()
+					if len(requests) != 2 || requests[0].Method != "DELETE" || requests[0].Body != "" || !strings.Contains(requests[1].Body, "vaulted-secret-5678") {
+						return fmt.Errorf("replace sent %+v, expected a DELETE without data then a POST", requests)
+					}
+					return nil
+				},
+				checkNotInState(address, "vaulted-secret-5678"),
+			),
+		},
+		// turning hash_data off saves the document again, without a request
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", withSettings(config, map[string]interface{}{
+				"data":      replaced["data"],
+				"hash_data": false,
+			})),
+			Check: resource.ComposeTestCheckFunc(
+				checkRequests(stub),
+				resource.TestCheckResourceAttr(address, "data", replaced["data"].(string)),
+			),
+		},
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", withSettings(config, replaced)),
+			Check: resource.ComposeTestCheckFunc(
+				checkRequests(stub),
+				checkNotInState(address, "vaulted-secret-5678"),
+			),
+		},
+	)
+}
+
+func TestResourceCamcVaultitemWriteOnly(t *testing.T) {
+	stub := newPatternManagerStub(t)
+	address := "camc_vaultitem.test"
+	config := map[string]interface{}{
+		"camc_endpoint":   stub.URL,
+		"access_token":    "access-token-1234",
+		"data_wo":         `{"name":"db","password":"write-only-1234"}`,
+		"data_wo_version": 1,
+	}
+	rotated := withSettings(config, map[string]interface{}{"data_wo": `{"name":"db","password":"write-only-5678"}`})
+
+	runSteps(t,
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", config),
+			Check: resource.ComposeTestCheckFunc(
+				func(*terraform.State) error {
+					requests := stub.Requests()
+					if len(requests) != 1 || requests[0].Body != `{"name":"db","password":"write-only-1234"}` {
+						return fmt.Errorf("create sent %+v, expected data_wo", requests)
+					}
+					return nil
+				},
+				checkNotInState(address, "write-only-1234"),
+			),
+		},
+		// a new value is only sent when the version changes
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", rotated),
+			Check:  checkRequests(stub),
+		},
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", withSettings(rotated, map[string]interface{}{"data_wo_version": 2})),
+			Check: resource.ComposeTestCheckFunc(
+				func(*terraform.State) error {
+					requests := stub.Requests()
+					if len(requests) != 2 || requests[0].Method != "DELETE" || requests[0].Body != "" || !strings.Contains(requests[1].Body, "write-only-5678") {
+						return fmt.Errorf("replace sent %+v, expected a DELETE then a POST of data_wo", requests)
+					}
+					return nil
+				},
+				checkNotInState(address, "write-only-5678"),
+			),
+		},
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", withSettings(rotated, map[string]interface{}{
+				"data_wo_version": 2,
+				"data":            `{"name":"db"}`,
+			})),
+			ExpectError: errorMatching(`"data": conflicts with data_wo`),
+		},
+		resource.TestStep{
+			Config: resourceConfig("camc_vaultitem", withSettings(rotated, map[string]interface{}{"data_wo_version": 2})),
+			Check:  checkRequests(stub),
+		},
+	)
+}
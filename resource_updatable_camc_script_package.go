@@ -5,16 +5,21 @@
 package main
 
 import (
+	"context"
+
 	"github.com/IBM-CAMHub-Open/terraform-provider-camc/common"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 func resourceCamcUpdatableScriptPackage() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceCamcUpdatableScriptPackageCreate,
-		Read:   resourceCamcUpdatableScriptPackageRead,
-		Update: resourceCamcUpdatableScriptPackageUpdate,
-		Delete: resourceCamcUpdatableScriptPackageDelete,
+		CreateContext: resourceCamcUpdatableScriptPackageCreate,
+		ReadContext:   resourceCamcUpdatableScriptPackageRead,
+		UpdateContext: resourceCamcUpdatableScriptPackageUpdate,
+		DeleteContext: resourceCamcUpdatableScriptPackageDelete,
+		CustomizeDiff: common.ScriptPackageCustomizeDiff,
 
 		Schema: map[string]*schema.Schema{
 			"program": &schema.Schema{
@@ -35,6 +40,12 @@ func resourceCamcUpdatableScriptPackage() *schema.Resource {
 				Sensitive: true,
 			},
 
+			"raw_command": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
 			"source": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
@@ -74,6 +85,31 @@ func resourceCamcUpdatableScriptPackage() *schema.Resource {
 				Sensitive: true,
 			},
 
+			"environment": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				ValidateDiagFunc: common.ValidateEnvironment,
+			},
+
+			"environment_sensitive": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:      schema.TypeString,
+					Sensitive: true,
+				},
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidateEnvironment,
+			},
+
+			"working_dir": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
 			"result": &schema.Schema{
 				Type:     schema.TypeMap,
 				Computed: true,
@@ -117,11 +153,55 @@ func resourceCamcUpdatableScriptPackage() *schema.Resource {
 			},
 
 			"remote_key": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidatePrivateKey,
+			},
+
+			"become": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"become_user": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"become_password": &schema.Schema{
 				Type:      schema.TypeString,
 				Optional:  true,
 				Sensitive: true,
 			},
 
+			"request_pty": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"pty_term": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "xterm",
+			},
+
+			"pty_width": &schema.Schema{
+				Type:             schema.TypeInt,
+				Optional:         true,
+				Default:          80,
+				ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+			},
+
+			"pty_height": &schema.Schema{
+				Type:             schema.TypeInt,
+				Optional:         true,
+				Default:          24,
+				ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+			},
+
 			"bastion_host": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
@@ -139,12 +219,19 @@ func resourceCamcUpdatableScriptPackage() *schema.Resource {
 			},
 
 			"bastion_private_key": &schema.Schema{
-				Type:      schema.TypeString,
-				Optional:  true,
-				Sensitive: true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        true,
+				ValidateDiagFunc: common.ValidatePrivateKey,
 			},
 
 			"bastion_port": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateDiagFunc: common.ValidatePort,
+			},
+
+			"log_file": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
 			},
@@ -164,7 +251,7 @@ func resourceCamcUpdatableScriptPackage() *schema.Resource {
 	}
 }
 
-func resourceCamcUpdatableScriptPackageCreate(d *schema.ResourceData, m interface{}) error {
+func resourceCamcUpdatableScriptPackageCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	if !d.Get("on_create").(bool) {
 		// Need to set an ID so that the resource gets created in Terraform
 		d.SetId(common.GenUUID())
@@ -173,48 +260,54 @@ func resourceCamcUpdatableScriptPackageCreate(d *schema.ResourceData, m interfac
 		d.Set("result", emptyResult)
 		return nil
 	}
-	result, err := common.RunScript(d, m)
+	// set ahead so the output of the program is logged with it
+	d.SetId(common.GenUUID())
+	result, diags := common.RunScript(ctx, d, m)
 
-	if err != nil {
-		return err
+	if diags.HasError() {
+		d.SetId("")
+		return diags
 	}
 
 	d.Set("result", result)
-	d.SetId(common.GenUUID())
-	return nil
+	return diags
 }
 
-func resourceCamcUpdatableScriptPackageRead(d *schema.ResourceData, m interface{}) error {
+func resourceCamcUpdatableScriptPackageRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	return nil
 }
 
-func resourceCamcUpdatableScriptPackageUpdate(d *schema.ResourceData, m interface{}) error {
+func resourceCamcUpdatableScriptPackageUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	// trace only changes how much is logged, it doesn't run the script again
+	if !d.HasChangesExcept("trace") {
+		return nil
+	}
 	if !d.Get("on_update").(bool) {
 		var emptyResult map[string]string
 		emptyResult = make(map[string]string)
 		d.Set("result", emptyResult)
 		return nil
 	}
-	return runUpdatableRequest(d, m)
+	return runUpdatableRequest(ctx, d, m)
 }
 
-func resourceCamcUpdatableScriptPackageDelete(d *schema.ResourceData, m interface{}) error {
+func resourceCamcUpdatableScriptPackageDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	if !d.Get("on_delete").(bool) {
 		var emptyResult map[string]string
 		emptyResult = make(map[string]string)
 		d.Set("result", emptyResult)
 		return nil
 	}
-	return runUpdatableRequest(d, m)
+	return runUpdatableRequest(ctx, d, m)
 }
 
-func runUpdatableRequest(d *schema.ResourceData, m interface{}) error {
-	result, err := common.RunScript(d, m)
+func runUpdatableRequest(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	result, diags := common.RunScript(ctx, d, m)
 
-	if err != nil {
-		return err
+	if diags.HasError() {
+		return diags
 	}
 
 	d.Set("result", result)
-	return nil
+	return diags
 }
@@ -0,0 +1,90 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestResourceCamcUpdatableScriptPackage(t *testing.T) {
+	dir := t.TempDir()
+	address := "camc_updatable_scriptpackage.test"
+	config := map[string]interface{}{
+		"program":     []interface{}{"sh", "-c", countingProgram},
+		"working_dir": dir,
+		"environment": map[string]interface{}{"NAME": "world"},
+		"on_create":   true,
+		"on_update":   true,
+	}
+	var id string
+
+	// on_delete isn't set, so destroying doesn't run it
+	runSteps(t,
+		resource.TestStep{
+			Config: resourceConfig("camc_updatable_scriptpackage", config),
+			Check: resource.ComposeTestCheckFunc(
+				resource.TestCheckResourceAttr(address, "result.runs", "1"),
+				func(s *terraform.State) error {
+					id = stateAttr(s, address, "id")
+					return nil
+				},
+			),
+		},
+		// trace doesn't run the program again
+		resource.TestStep{
+			Config: resourceConfig("camc_updatable_scriptpackage", withSettings(config, map[string]interface{}{"trace": true})),
+			Check:  resource.TestCheckResourceAttr(address, "result.runs", "1"),
+		},
+		// the program runs again in place
+		resource.TestStep{
+			Config: resourceConfig("camc_updatable_scriptpackage", withSettings(config, map[string]interface{}{
+				"environment": map[string]interface{}{"NAME": "again"},
+			})),
+			Check: resource.ComposeTestCheckFunc(
+				func(s *terraform.State) error {
+					if stateAttr(s, address, "id") != id {
+						return fmt.Errorf("update replaced the resource")
+					}
+					return nil
+				},
+				resource.TestCheckResourceAttr(address, "result.greeting", "hello again"),
+				resource.TestCheckResourceAttr(address, "result.runs", "2"),
+			),
+		},
+	)
+}
+
+func TestResourceCamcUpdatableScriptPackageRemote(t *testing.T) {
+	server := newSSHTestServer(t)
+	provider := remoteProviderConfig(t)
+	config := remoteConfig(server, map[string]interface{}{
+		"program":   []interface{}{"cat"},
+		"query":     map[string]interface{}{"answer": "42"},
+		"on_create": true,
+		"on_update": true,
+		"on_delete": true,
+	})
+	runTestCase(t, resource.TestCase{
+		Steps: []resource.TestStep{
+			{Config: provider + resourceConfig("camc_updatable_scriptpackage", config)},
+			{
+				Config: provider + resourceConfig("camc_updatable_scriptpackage", withSettings(config, map[string]interface{}{
+					"query": map[string]interface{}{"answer": "43"},
+				})),
+				Check: resource.TestCheckResourceAttr("camc_updatable_scriptpackage.test", "result.answer", "43"),
+			},
+		},
+		CheckDestroy: func(*terraform.State) error {
+			if commands := server.Commands(); len(commands) != 3 {
+				return fmt.Errorf("remote host ran %q", commands)
+			}
+			return nil
+		},
+	})
+}
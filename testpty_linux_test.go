@@ -0,0 +1,90 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+// The terminal flags the ssh test server applies from the modes of a pty-req,
+// by opcode
+var testPtyFlags = map[uint8]struct {
+	field func(*unix.Termios) *uint32
+	flag  uint32
+}{
+	ssh.ECHO:   {func(t *unix.Termios) *uint32 { return &t.Lflag }, unix.ECHO},
+	ssh.ICANON: {func(t *unix.Termios) *uint32 { return &t.Lflag }, unix.ICANON},
+	ssh.ISIG:   {func(t *unix.Termios) *uint32 { return &t.Lflag }, unix.ISIG},
+	ssh.IEXTEN: {func(t *unix.Termios) *uint32 { return &t.Lflag }, unix.IEXTEN},
+	ssh.IXON:   {func(t *unix.Termios) *uint32 { return &t.Iflag }, unix.IXON},
+	ssh.ICRNL:  {func(t *unix.Termios) *uint32 { return &t.Iflag }, unix.ICRNL},
+	ssh.ONLCR:  {func(t *unix.Termios) *uint32 { return &t.Oflag }, unix.ONLCR},
+}
+
+// Starts cmd with a new pseudo terminal of the size and modes given as its
+// controlling terminal and standard streams, and returns the master side
+func startWithPty(cmd *exec.Cmd, req ptyRequest) (*os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	defer slave.Close()
+
+	termios, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	for opcode, value := range req.modes() {
+		f, ok := testPtyFlags[opcode]
+		if !ok {
+			continue
+		}
+		if value != 0 {
+			*f.field(termios) |= f.flag
+		} else {
+			*f.field(termios) &^= f.flag
+		}
+	}
+	if err := unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, termios); err != nil {
+		master.Close()
+		return nil, err
+	}
+	if err := unix.IoctlSetWinsize(int(slave.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: uint16(req.Rows), Col: uint16(req.Columns)}); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+	return master, nil
+}
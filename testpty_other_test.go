@@ -0,0 +1,19 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// The ssh test server only allocates pseudo terminals on Linux
+func startWithPty(cmd *exec.Cmd, req ptyRequest) (*os.File, error) {
+	return nil, errors.New("the ssh test server has no pseudo terminals on " + runtime.GOOS)
+}
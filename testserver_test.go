@@ -0,0 +1,879 @@
+//
+// Copyright : IBM Corporation 2026, 2026
+//
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testSSHUser     = "camc"
+	testSSHPassword = "ssh-password-1234"
+)
+
+// sshTestServer stands in for a remote host. It runs the commands it's sent
+// with the local shell in its home directory, serves SFTP from there, and
+// forwards direct-tcpip channels so it can act as a bastion host too.
+//
+// Each server has a name, such as ssh-1.example, that the proxy stubs and the
+// tunnels of the bastion hosts resolve to it on port 22, so the provider can
+// reach it as remote_host.
+type sshTestServer struct {
+	name string
+	host string
+	port string
+	home string
+	// base64 encoded private key accepted for testSSHUser, as remote_key takes it
+	clientKey string
+
+	// holds the programs that stand in for those of the host, such as sudo
+	bin string
+
+	mu        sync.Mutex
+	rejectEnv bool
+	commands  []string
+	stdin     []string
+	env       []string
+	tunnels   []string
+}
+
+func newSSHTestServer(t *testing.T) *sshTestServer {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublic, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == testSSHUser && string(password) == testSSHPassword {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == testSSHUser && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	s := &sshTestServer{
+		name:      fmt.Sprintf("ssh-%d.example", testHostCount.Add(1)),
+		host:      host,
+		port:      port,
+		home:      t.TempDir(),
+		bin:       t.TempDir(),
+		clientKey: base64.StdEncoding.EncodeToString(pem.EncodeToMemory(block)),
+	}
+	testHosts.Store(s.name+":22", s.addr())
+	t.Cleanup(func() { testHosts.Delete(s.name + ":22") })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn, config)
+		}
+	}()
+	return s
+}
+
+// The addresses of the ssh test servers, by name:22
+var (
+	testHosts     sync.Map
+	testHostCount atomic.Int64
+)
+
+// Returns the address that connections to target are made to, that of the
+// ssh test server it names or target itself
+func resolveTestHost(target string) string {
+	if addr, ok := testHosts.Load(target); ok {
+		return addr.(string)
+	}
+	return target
+}
+
+// Returns the address the server listens on
+func (s *sshTestServer) addr() string {
+	return net.JoinHostPort(s.host, s.port)
+}
+
+// Returns the commands run on the server so far
+func (s *sshTestServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Returns the environment variables set with setenv so far
+func (s *sshTestServer) Env() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.env...)
+}
+
+// Returns what the commands run so far were sent on stdin, in the order of
+// Commands
+func (s *sshTestServer) Stdin() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.stdin...)
+}
+
+// Installs a sudo that asks for password, or for none if it's empty as with
+// NOPASSWD. Like sudo, -n fails rather than ask, -S reads the password from
+// the first line of stdin, and the program is run with a reset environment
+// where USER is the -u user. The user missing doesn't exist.
+func (s *sshTestServer) Sudo(t *testing.T, password string) {
+	t.Helper()
+	script := `#!/bin/sh
+required=` + shellQuoteTest(password) + `
+user=root
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-n) noninteractive=1 ;;
+	-S) stdin=1 ;;
+	-k) ;;
+	-p) shift ;;
+	-u) shift; user=$1 ;;
+	--) shift; break ;;
+	*) break ;;
+	esac
+	shift
+done
+if [ "$user" = missing ]; then
+	echo "sudo: unknown user $user" >&2
+	exit 1
+fi
+if [ -n "$required" ]; then
+	if [ -n "$noninteractive" ] || [ -z "$stdin" ]; then
+		echo "sudo: a password is required" >&2
+		exit 1
+	fi
+	IFS= read -r password
+	if [ "$password" != "$required" ]; then
+		echo "sudo: incorrect password" >&2
+		exit 1
+	fi
+fi
+exec env -i PATH="$PATH" HOME="$HOME" USER="$user" "$@"
+`
+	if err := os.WriteFile(filepath.Join(s.bin, "sudo"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Quotes s for the shell, as the provider does
+func shellQuoteTest(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Rejects setenv requests from now on, as sshd does for names that AcceptEnv
+// doesn't list
+func (s *sshTestServer) RejectEnv() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejectEnv = true
+}
+
+// Returns the addresses forwarded to so far
+func (s *sshTestServer) Tunnels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tunnels...)
+}
+
+func (s *sshTestServer) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.serveSession(newChannel)
+		case "direct-tcpip":
+			go s.serveTunnel(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, newChannel.ChannelType())
+		}
+	}
+}
+
+func (s *sshTestServer) serveSession(newChannel ssh.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	env := []string{"PATH=" + s.bin + string(os.PathListSeparator) + os.Getenv("PATH"), "HOME=" + s.home}
+	var pty *ptyRequest
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			pty = &ptyRequest{}
+			if err := ssh.Unmarshal(req.Payload, pty); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			env = append(env, "TERM="+pty.Term)
+			req.Reply(true, nil)
+		case "env":
+			var payload struct{ Name, Value string }
+			ssh.Unmarshal(req.Payload, &payload)
+			s.mu.Lock()
+			accept := !s.rejectEnv
+			if accept {
+				s.env = append(s.env, payload.Name)
+			}
+			s.mu.Unlock()
+			if accept {
+				env = append(env, payload.Name+"="+payload.Value)
+			}
+			req.Reply(accept, nil)
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			s.mu.Lock()
+			s.commands = append(s.commands, payload.Command)
+			s.stdin = append(s.stdin, "")
+			n := len(s.commands) - 1
+			s.mu.Unlock()
+			req.Reply(true, nil)
+			go s.run(channel, payload.Command, env, n, pty)
+		case "subsystem":
+			var payload struct{ Name string }
+			ssh.Unmarshal(req.Payload, &payload)
+			if payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(s.home))
+			if err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go func() {
+				server.Serve()
+				channel.Close()
+			}()
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// ptyRequest is the payload of a pty-req
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+// Returns the terminal modes requested, by opcode
+func (req ptyRequest) modes() map[uint8]uint32 {
+	modes := make(map[uint8]uint32)
+	list := []byte(req.Modes)
+	// each mode is an opcode followed by a 32 bit value, up to TTY_OP_END
+	for len(list) >= 5 && list[0] != 0 {
+		modes[list[0]] = binary.BigEndian.Uint32(list[1:5])
+		list = list[5:]
+	}
+	return modes
+}
+
+// stdinRecorder appends what it's written to the stdin recorded for the nth
+// command
+type stdinRecorder struct {
+	s *sshTestServer
+	n int
+}
+
+func (r stdinRecorder) Write(p []byte) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.stdin[r.n] += string(p)
+	return len(p), nil
+}
+
+// Runs the nth command, with a pseudo terminal if one was requested, and
+// records what it's sent on stdin
+func (s *sshTestServer) run(channel ssh.Channel, command string, env []string, n int, pty *ptyRequest) {
+	defer channel.Close()
+	stdin := io.TeeReader(channel, stdinRecorder{s, n})
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Dir = s.home
+	cmd.Env = env
+	var err error
+	if pty != nil {
+		err = runWithPty(cmd, *pty, channel, stdin)
+	} else {
+		cmd.Stdin = stdin
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		err = cmd.Run()
+	}
+	status := 0
+	if err != nil {
+		status = 255
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			status = exitErr.ExitCode()
+		} else {
+			fmt.Fprintln(channel.Stderr(), err)
+		}
+	}
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+// Runs cmd with a pseudo terminal, which carries stdin to it and both its
+// stdout and stderr back, as sshd does
+func runWithPty(cmd *exec.Cmd, req ptyRequest, channel ssh.Channel, stdin io.Reader) error {
+	master, err := startWithPty(cmd, req)
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+	go io.Copy(master, stdin)
+	// reading fails once the program and whatever it started have exited
+	io.Copy(channel, master)
+	return cmd.Wait()
+}
+
+func (s *sshTestServer) serveTunnel(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	target := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+	conn, err := net.Dial("tcp", resolveTestHost(target))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.mu.Lock()
+	s.tunnels = append(s.tunnels, target)
+	s.mu.Unlock()
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(channel, conn)
+		channel.Close()
+	}()
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+}
+
+// patternManagerRequest is a request received by the pattern manager stub
+type patternManagerRequest struct {
+	Method        string
+	Path          string
+	Proto         string
+	Authorization string
+	UserAgent     string
+	Header        http.Header
+	Body          string
+}
+
+// patternManagerStub stands in for the pattern manager. It answers every
+// request with the configured status and body, and records what it was sent.
+type patternManagerStub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	body     string
+	token    string
+	queue    []stubResponse
+	requests []patternManagerRequest
+	conns    int
+}
+
+// stubResponse is a response the pattern manager stub has been told to give
+type stubResponse struct {
+	status int
+	header http.Header
+	body   string
+}
+
+func newPatternManagerStub(t *testing.T) *patternManagerStub {
+	t.Helper()
+	stub := newUnstartedPatternManagerStub(t)
+	stub.Start()
+	return stub
+}
+
+// Like newPatternManagerStub, serving HTTPS with config. The server
+// certificate is httptest's, valid for 127.0.0.1 and example.com.
+func newPatternManagerStubTLS(t *testing.T, config *tls.Config) *patternManagerStub {
+	t.Helper()
+	stub := newUnstartedPatternManagerStub(t)
+	stub.TLS = config
+	stub.StartTLS()
+	return stub
+}
+
+func newUnstartedPatternManagerStub(t *testing.T) *patternManagerStub {
+	stub := &patternManagerStub{status: http.StatusOK, body: `{"status":"ok"}`}
+	stub.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.requests = append(stub.requests, patternManagerRequest{
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Proto:         r.Proto,
+			Authorization: r.Header.Get("Authorization"),
+			UserAgent:     r.Header.Get("User-Agent"),
+			Header:        r.Header.Clone(),
+			Body:          string(body),
+		})
+		w.Header().Set("Content-Type", "application/json")
+		if stub.token != "" && r.Header.Get("Authorization") != "Bearer "+stub.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"message":"invalid token"}`)
+			return
+		}
+		if len(stub.queue) > 0 {
+			next := stub.queue[0]
+			stub.queue = stub.queue[1:]
+			for k, v := range next.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(next.status)
+			io.WriteString(w, next.body)
+			return
+		}
+		w.WriteHeader(stub.status)
+		io.WriteString(w, stub.body)
+	}))
+	stub.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			stub.mu.Lock()
+			stub.conns++
+			stub.mu.Unlock()
+		}
+	}
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// Returns the number of connections made to the stub
+func (stub *patternManagerStub) Connections() int {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return stub.conns
+}
+
+// Returns the PEM encoded certificate of a TLS stub, to trust it with
+func (stub *patternManagerStub) CertificatePEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: stub.Certificate().Raw}))
+}
+
+// Sets the response to the requests that follow
+func (stub *patternManagerStub) Respond(status int, body string) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.status = status
+	stub.body = body
+}
+
+// Gives a response with header to the next request only, before those set
+// with Respond. Responses queued one after another are given in order.
+func (stub *patternManagerStub) Queue(status int, header http.Header, body string) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.queue = append(stub.queue, stubResponse{status: status, header: header, body: body})
+}
+
+// Rejects requests that don't have token as their bearer token
+func (stub *patternManagerStub) RequireToken(token string) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.token = token
+}
+
+// Returns the requests received so far and forgets them
+func (stub *patternManagerStub) Requests() []patternManagerRequest {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	requests := stub.requests
+	stub.requests = nil
+	return requests
+}
+
+// identityStub stands in for an OAuth or IAM token endpoint. It issues
+// numbered tokens, issued-token-1, issued-token-2, ... and records the form
+// of every request.
+type identityStub struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	forms  []url.Values
+	client string
+}
+
+func newIdentityStub(t *testing.T) *identityStub {
+	t.Helper()
+	stub := newUnstartedIdentityStub(t)
+	stub.Start()
+	return stub
+}
+
+// Like newIdentityStub, serving HTTPS with httptest's certificate
+func newIdentityStubTLS(t *testing.T) *identityStub {
+	t.Helper()
+	stub := newUnstartedIdentityStub(t)
+	stub.StartTLS()
+	return stub
+}
+
+func newUnstartedIdentityStub(t *testing.T) *identityStub {
+	stub := &identityStub{}
+	stub.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.forms = append(stub.forms, r.PostForm)
+		stub.client, _, _ = r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"issued-token-%d","token_type":"Bearer","expires_in":3600}`, len(stub.forms))
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// Returns the forms of the token requests received so far
+func (stub *identityStub) Forms() []url.Values {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return append([]url.Values(nil), stub.forms...)
+}
+
+// Returns the PEM encoded certificate of a TLS stub, to trust it with
+func (stub *identityStub) CertificatePEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: stub.Certificate().Raw}))
+}
+
+// Returns the client the last token request authenticated as
+func (stub *identityStub) Client() string {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return stub.client
+}
+
+// Returns a self-signed client certificate and its key, PEM encoded, and a
+// pool that trusts it for a server to verify clients with.
+func newTestClientCertificate(t *testing.T) (string, string, *x509.CertPool) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "camc test client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})), pool
+}
+
+// Returns a server whose responses end before the body they announce
+func newTruncatingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"status\":")
+		buf.Flush()
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// proxyStub stands in for an HTTP or SOCKS5 proxy. It connects to the
+// addresses it's asked for, or to those that routes maps them to, and records
+// them. It requires the credentials if username is set.
+type proxyStub struct {
+	URL string
+
+	username string
+	password string
+	routes   map[string]string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+// Serves an HTTP proxy that forwards requests and tunnels CONNECT requests
+func newHTTPProxyStub(t *testing.T, username string, password string, routes map[string]string) *proxyStub {
+	t.Helper()
+	p := &proxyStub{username: username, password: password, routes: routes}
+	transport := &http.Transport{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.username != "" {
+			credentials := &http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}
+			if username, password, ok := credentials.BasicAuth(); !ok || username != p.username || password != p.password {
+				w.WriteHeader(http.StatusProxyAuthRequired)
+				return
+			}
+		}
+		if r.Method == http.MethodConnect {
+			upstream, err := p.dial(r.Host)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				upstream.Close()
+				return
+			}
+			io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			pipe(conn, rw, upstream)
+			return
+		}
+		r.URL.Host = p.route(r.URL.Host)
+		r.RequestURI = ""
+		r.Header.Del("Proxy-Authorization")
+		resp, err := transport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(transport.CloseIdleConnections)
+	p.URL = server.URL
+	return p
+}
+
+// Serves a SOCKS5 proxy, with username and password authentication
+func newSOCKS5ProxyStub(t *testing.T, username string, password string) *proxyStub {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	p := &proxyStub{URL: "socks5://" + l.Addr().String(), username: username, password: password}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serveSOCKS5(conn)
+		}
+	}()
+	return p
+}
+
+func (p *proxyStub) serveSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	// the greeting, then the credentials as RFC 1929 has them
+	head := read(2)
+	if head == nil || head[0] != 5 || read(int(head[1])) == nil {
+		return
+	}
+	if p.username == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		head := read(2)
+		if head == nil {
+			return
+		}
+		username := read(int(head[1]))
+		n := read(1)
+		if n == nil {
+			return
+		}
+		password := read(int(n[0]))
+		if string(username) != p.username || string(password) != p.password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+	request := read(4)
+	if request == nil || request[1] != 1 {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		host = net.IP(read(net.IPv4len)).String()
+	case 4:
+		host = net.IP(read(net.IPv6len)).String()
+	case 3:
+		n := read(1)
+		if n == nil {
+			return
+		}
+		host = string(read(int(n[0])))
+	}
+	port := read(2)
+	if port == nil {
+		return
+	}
+	upstream, err := p.dial(net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(conn, conn, upstream)
+}
+
+// Records target and returns the address it's routed to
+func (p *proxyStub) route(target string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.targets = append(p.targets, target)
+	if addr, ok := p.routes[target]; ok {
+		return addr
+	}
+	return resolveTestHost(target)
+}
+
+func (p *proxyStub) dial(target string) (net.Conn, error) {
+	return net.Dial("tcp", p.route(target))
+}
+
+// Returns the addresses the proxy was asked to connect to so far and forgets
+// them
+func (p *proxyStub) Targets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	targets := p.targets
+	p.targets = nil
+	return targets
+}
+
+// Copies between conn, read through r, and upstream until either ends
+func pipe(conn net.Conn, r io.Reader, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, r)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	conn.Close()
+	upstream.Close()
+}